@@ -0,0 +1,205 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// AsyncOp identifies the kind of an asynchronous request
+type AsyncOp int
+
+const (
+	ASYNC_GET AsyncOp = iota
+	ASYNC_SET
+	ASYNC_DELETE
+	numAsyncOps
+)
+
+var AsyncKVStoreClosed = fmt.Errorf("async kvstore already closed")
+var AsyncQueueFull = fmt.Errorf("async kvstore queue full")
+
+// Future is the pending result of an asynchronous request
+type Future struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+func newFailedFuture(err error) *Future {
+	rv := &Future{done: make(chan struct{}), err: err}
+	close(rv.done)
+	return rv
+}
+
+// Done returns a channel which is closed once the request has completed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the request has completed and returns its result.
+// The value is only set for ASYNC_GET requests.
+func (f *Future) Wait() ([]byte, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// AsyncLatency summarizes the latency, from submission to completion,
+// of the requests of one kind completed so far
+type AsyncLatency struct {
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the mean latency
+func (l AsyncLatency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+type asyncReq struct {
+	op      AsyncOp
+	key     []byte
+	value   []byte
+	started time.Time
+	future  *Future
+}
+
+// AsyncKVStore runs all calls on a KVStore from a dedicated goroutine
+// locked to its own OS thread, so a stalled cgo call only ever blocks
+// that thread.  Requests are queued in a bounded queue; when it is full
+// new requests fail immediately with AsyncQueueFull.
+//
+// Keys and values passed to the asynchronous methods must not be
+// modified until the returned Future has completed.  The KVStore must
+// not be used directly until Close returns.
+type AsyncKVStore struct {
+	kvs *KVStore
+
+	closedMutex sync.RWMutex
+	closed      bool
+	reqs        chan *asyncReq
+	done        chan struct{}
+
+	statsMutex sync.Mutex
+	latency    [numAsyncOps]AsyncLatency
+}
+
+// NewAsyncKVStore starts a worker for kvs with a queue of queueSize requests
+func NewAsyncKVStore(kvs *KVStore, queueSize int) *AsyncKVStore {
+	rv := &AsyncKVStore{
+		kvs:  kvs,
+		reqs: make(chan *asyncReq, queueSize),
+		done: make(chan struct{}),
+	}
+	go rv.run()
+	return rv
+}
+
+// GetAsync looks up the value for key
+func (a *AsyncKVStore) GetAsync(key []byte) *Future {
+	return a.submit(ASYNC_GET, key, nil)
+}
+
+// SetAsync stores the value for key
+func (a *AsyncKVStore) SetAsync(key, value []byte) *Future {
+	return a.submit(ASYNC_SET, key, value)
+}
+
+// DeleteAsync deletes key
+func (a *AsyncKVStore) DeleteAsync(key []byte) *Future {
+	return a.submit(ASYNC_DELETE, key, nil)
+}
+
+// QueueDepth returns the number of requests waiting to be processed
+func (a *AsyncKVStore) QueueDepth() int {
+	return len(a.reqs)
+}
+
+// Latency returns the latency summary for requests of the given kind
+func (a *AsyncKVStore) Latency(op AsyncOp) AsyncLatency {
+	a.statsMutex.Lock()
+	defer a.statsMutex.Unlock()
+	return a.latency[op]
+}
+
+func (a *AsyncKVStore) submit(op AsyncOp, key, value []byte) *Future {
+	req := &asyncReq{
+		op:      op,
+		key:     key,
+		value:   value,
+		started: time.Now(),
+		future:  &Future{done: make(chan struct{})},
+	}
+
+	a.closedMutex.RLock()
+	defer a.closedMutex.RUnlock()
+	if a.closed {
+		return newFailedFuture(AsyncKVStoreClosed)
+	}
+	select {
+	case a.reqs <- req:
+		return req.future
+	default:
+		return newFailedFuture(AsyncQueueFull)
+	}
+}
+
+func (a *AsyncKVStore) run() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(a.done)
+
+	for req := range a.reqs {
+		f := req.future
+		switch req.op {
+		case ASYNC_GET:
+			f.value, f.err = a.kvs.GetKV(req.key)
+		case ASYNC_SET:
+			f.err = a.kvs.SetKV(req.key, req.value)
+		case ASYNC_DELETE:
+			f.err = a.kvs.DeleteKV(req.key)
+		}
+
+		elapsed := time.Since(req.started)
+		a.statsMutex.Lock()
+		l := &a.latency[req.op]
+		l.Count++
+		l.Total += elapsed
+		if elapsed > l.Max {
+			l.Max = elapsed
+		}
+		a.statsMutex.Unlock()
+
+		close(f.done)
+	}
+}
+
+// Close processes the requests already queued, then stops the worker.
+// It does not close the underlying KVStore.
+func (a *AsyncKVStore) Close() error {
+	a.closedMutex.Lock()
+	if a.closed {
+		a.closedMutex.Unlock()
+		return AsyncKVStoreClosed
+	}
+	a.closed = true
+	close(a.reqs)
+	a.closedMutex.Unlock()
+
+	<-a.done
+	return nil
+}
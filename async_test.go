@@ -0,0 +1,101 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAsyncKVStore(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	async := NewAsyncKVStore(kvstore, 16)
+
+	set := async.SetAsync([]byte("key1"), []byte("value1"))
+	get := async.GetAsync([]byte("key1"))
+	del := async.DeleteAsync([]byte("key1"))
+	miss := async.GetAsync([]byte("key1"))
+
+	_, err = set.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	val, err := get.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	if string(val) != "value1" {
+		t.Errorf("expected value1, got %s", val)
+	}
+	_, err = del.Wait()
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = miss.Wait()
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+
+	if async.QueueDepth() != 0 {
+		t.Errorf("expected empty queue, got %d", async.QueueDepth())
+	}
+	l := async.Latency(ASYNC_GET)
+	if l.Count != 2 || l.Max < l.Mean() {
+		t.Errorf("unexpected get latency %+v", l)
+	}
+
+	err = async.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = async.GetAsync([]byte("key1")).Wait()
+	if err != AsyncKVStoreClosed {
+		t.Errorf("expected %v, got %v", AsyncKVStoreClosed, err)
+	}
+}
+
+func TestAsyncKVStoreQueueFull(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// an unbuffered queue only accepts a request while the worker is idle
+	async := NewAsyncKVStore(kvstore, 0)
+	defer async.Close()
+
+	full := 0
+	var futures []*Future
+	for i := 0; i < 100; i++ {
+		f := async.SetAsync([]byte("key"), []byte("value"))
+		futures = append(futures, f)
+	}
+	for _, f := range futures {
+		_, err := f.Wait()
+		if err == AsyncQueueFull {
+			full++
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if full == 0 {
+		t.Errorf("expected some requests to be rejected")
+	}
+}
@@ -0,0 +1,310 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+)
+
+// appended to the name of a KVStore to name the reserved KVStore
+// holding its filter.  Index names may not contain ':', so this cannot
+// collide with the KVStore of an IndexedStore index.
+const bloomStoreSuffix = ":bloom"
+
+// the key of the filter doc in the reserved KVStore
+var bloomFilterKey = []byte("filter")
+
+const bloomFilterVersion = 1
+
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint32
+}
+
+// newBloomFilter sizes a filter for n keys with false positive rate p
+func newBloomFilter(n uint64, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Ceil(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		hashes: k,
+	}
+}
+
+// positions calls f with the k bit positions of key, derived from two
+// halves of its FNV-1a hash by double hashing
+func (b *bloomFilter) positions(key []byte, f func(pos uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&0xFFFFFFFF, sum>>32|1
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < uint64(b.hashes); i++ {
+		if !f((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) add(key []byte) {
+	b.positions(key, func(pos uint64) bool {
+		b.bits[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+}
+
+func (b *bloomFilter) mayContain(key []byte) bool {
+	return b.positions(key, func(pos uint64) bool {
+		return b.bits[pos/64]&(1<<(pos%64)) != 0
+	})
+}
+
+// encode returns the filter, stamped with the sequence number of the
+// KVStore it describes
+func (b *bloomFilter) encode(seqnum SeqNum) []byte {
+	rv := make([]byte, 13+8*len(b.bits))
+	rv[0] = bloomFilterVersion
+	binary.BigEndian.PutUint32(rv[1:], b.hashes)
+	binary.BigEndian.PutUint64(rv[5:], uint64(seqnum))
+	for i, word := range b.bits {
+		binary.BigEndian.PutUint64(rv[13+8*i:], word)
+	}
+	return rv
+}
+
+func decodeBloomFilter(buf []byte) (*bloomFilter, SeqNum, bool) {
+	if len(buf) <= 13 || buf[0] != bloomFilterVersion || (len(buf)-13)%8 != 0 {
+		return nil, 0, false
+	}
+	rv := bloomFilter{
+		bits:   make([]uint64, (len(buf)-13)/8),
+		hashes: binary.BigEndian.Uint32(buf[1:]),
+	}
+	for i := range rv.bits {
+		rv.bits[i] = binary.BigEndian.Uint64(buf[13+8*i:])
+	}
+	return &rv, SeqNum(binary.BigEndian.Uint64(buf[5:])), rv.hashes > 0
+}
+
+// BloomStats reports the effectiveness of a BloomKVStore's filter
+type BloomStats struct {
+	// Number of reads checked against the filter
+	Lookups uint64
+	// Reads answered with RESULT_KEY_NOT_FOUND by the filter alone
+	Skipped uint64
+	// Reads the filter let through for keys which did not exist
+	FalsePositives uint64
+}
+
+// BloomKVStore wraps a KVStore with a Bloom filter of its keys, so that
+// reads of keys which definitely do not exist return RESULT_KEY_NOT_FOUND
+// without calling into ForestDB.  The filter is kept in memory, updated
+// by writes through the BloomKVStore, and persisted in a reserved KVStore
+// of the same File by Save and Close.  Writes made to the KVStore
+// directly are not seen by the filter and must be followed by Rebuild.
+// Like KVStore, a BloomKVStore must only be used by one goroutine at a
+// time.
+type BloomKVStore struct {
+	kvs    *KVStore
+	store  *KVStore
+	keys   uint64
+	fpRate float64
+	filter *bloomFilter
+	stats  BloomStats
+}
+
+// OpenBloomKVStore adds a filter to kvs, sized for expectedKeys keys
+// with false positive rate fpRate.  The persisted filter is loaded if
+// it is up to date with kvs, otherwise it is rebuilt by scanning kvs.
+func OpenBloomKVStore(kvs *KVStore, expectedKeys uint64, fpRate float64) (*BloomKVStore, error) {
+	if kvs.f == nil || fpRate <= 0 || fpRate >= 1 {
+		return nil, RESULT_INVALID_ARGS
+	}
+	store, err := kvs.f.OpenKVStore(kvs.name+bloomStoreSuffix, nil)
+	if err != nil {
+		return nil, err
+	}
+	rv := BloomKVStore{
+		kvs:    kvs,
+		store:  store,
+		keys:   expectedKeys,
+		fpRate: fpRate,
+	}
+
+	err = rv.load()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// load reads the persisted filter, falling back to Rebuild if there is
+// none or it does not reflect the current state of the KVStore
+func (b *BloomKVStore) load() error {
+	info, err := b.kvs.Info()
+	if err != nil {
+		return err
+	}
+	buf, err := b.store.GetKV(bloomFilterKey)
+	if err == RESULT_KEY_NOT_FOUND {
+		return b.Rebuild()
+	} else if err != nil {
+		return err
+	}
+	filter, seqnum, ok := decodeBloomFilter(buf)
+	if !ok || seqnum != info.LastSeqNum() {
+		return b.Rebuild()
+	}
+	b.filter = filter
+	return nil
+}
+
+// Rebuild recreates the filter from the keys currently in the KVStore,
+// which also drops deleted keys from it
+func (b *BloomKVStore) Rebuild() error {
+	info, err := b.kvs.Info()
+	if err != nil {
+		return err
+	}
+	n := b.keys
+	if info.DocCount() > n {
+		n = info.DocCount()
+	}
+	filter := newBloomFilter(n, b.fpRate)
+
+	iter, err := b.kvs.IteratorInit(nil, nil, ITR_NO_DELETES)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		doc, err := iter.GetMetaOnly()
+		if isIterEnd(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		filter.add(doc.Key())
+		doc.Close()
+		if iter.Next() != nil {
+			break
+		}
+	}
+	b.filter = filter
+	return nil
+}
+
+// KVStore returns the underlying KVStore
+func (b *BloomKVStore) KVStore() *KVStore {
+	return b.kvs
+}
+
+// Stats returns the filter metrics
+func (b *BloomKVStore) Stats() BloomStats {
+	return b.stats
+}
+
+// absent returns whether the filter rules out key
+func (b *BloomKVStore) absent(key []byte) bool {
+	b.stats.Lookups++
+	if !b.filter.mayContain(key) {
+		b.stats.Skipped++
+		return true
+	}
+	return false
+}
+
+func (b *BloomKVStore) checkMiss(err error) error {
+	if err == RESULT_KEY_NOT_FOUND {
+		b.stats.FalsePositives++
+	}
+	return err
+}
+
+// GetKV returns the value for key
+func (b *BloomKVStore) GetKV(key []byte) ([]byte, error) {
+	if b.absent(key) {
+		return nil, RESULT_KEY_NOT_FOUND
+	}
+	rv, err := b.kvs.GetKV(key)
+	return rv, b.checkMiss(err)
+}
+
+// Get retrieves the metadata and doc body for the key of doc
+func (b *BloomKVStore) Get(doc *Doc) error {
+	if b.absent(doc.Key()) {
+		return RESULT_KEY_NOT_FOUND
+	}
+	return b.checkMiss(b.kvs.Get(doc))
+}
+
+// SetKV stores the value for key and adds key to the filter
+func (b *BloomKVStore) SetKV(key, value []byte) error {
+	b.filter.add(key)
+	return b.kvs.SetKV(key, value)
+}
+
+// Set stores the metadata and body of doc and adds its key to the filter
+func (b *BloomKVStore) Set(doc *Doc) error {
+	b.filter.add(doc.Key())
+	return b.kvs.Set(doc)
+}
+
+// DeleteKV deletes key.  The key stays in the filter until Rebuild.
+func (b *BloomKVStore) DeleteKV(key []byte) error {
+	return b.kvs.DeleteKV(key)
+}
+
+// Delete deletes the key of doc.  The key stays in the filter until Rebuild.
+func (b *BloomKVStore) Delete(doc *Doc) error {
+	return b.kvs.Delete(doc)
+}
+
+// Compact compacts the File into newfilename and rebuilds the filter
+// without the keys deleted since it was built
+func (b *BloomKVStore) Compact(newfilename string) error {
+	err := b.kvs.File().Compact(newfilename)
+	if err != nil {
+		return err
+	}
+	return b.Rebuild()
+}
+
+// Save writes the filter to the reserved KVStore.  Like any other
+// write, it is persisted by the next commit of the File; a filter
+// which is not saved along with the last commit is rebuilt on open.
+func (b *BloomKVStore) Save() error {
+	info, err := b.kvs.Info()
+	if err != nil {
+		return err
+	}
+	return b.store.SetKV(bloomFilterKey, b.filter.encode(info.LastSeqNum()))
+}
+
+// Close saves the filter and closes the reserved KVStore; the wrapped
+// KVStore is left open
+func (b *BloomKVStore) Close() error {
+	err := b.Save()
+	if err != nil {
+		b.store.Close()
+		return err
+	}
+	return b.store.Close()
+}
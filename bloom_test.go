@@ -0,0 +1,127 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		filter.add([]byte(fmt.Sprintf("key%d", i)))
+	}
+	for i := 0; i < 1000; i++ {
+		if !filter.mayContain([]byte(fmt.Sprintf("key%d", i))) {
+			t.Fatalf("false negative for key%d", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.mayContain([]byte(fmt.Sprintf("other%d", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("expected about 1%% false positives, got %d in 10000", falsePositives)
+	}
+
+	decoded, seqnum, ok := decodeBloomFilter(filter.encode(42))
+	if !ok || seqnum != 42 || decoded.hashes != filter.hashes || len(decoded.bits) != len(filter.bits) {
+		t.Errorf("filter did not survive encoding")
+	}
+}
+
+func TestBloomKVStore(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for i := 0; i < 100; i++ {
+		err = kvstore.SetKV([]byte(fmt.Sprintf("key%d", i)), []byte("val"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// existing keys are picked up by the initial rebuild
+	bloom, err := OpenBloomKVStore(kvstore, 1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bloom.SetKV([]byte("new"), []byte("val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key0", "key99", "new"} {
+		_, err = bloom.GetKV([]byte(key))
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		_, err = bloom.GetKV([]byte(fmt.Sprintf("missing%d", i)))
+		if err != RESULT_KEY_NOT_FOUND {
+			t.Fatalf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+		}
+	}
+	stats := bloom.Stats()
+	if stats.Lookups != 103 || stats.Skipped+stats.FalsePositives != 100 || stats.Skipped < 90 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	err = bloom.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the saved filter is reused, and still knows the key set through it
+	bloom, err = OpenBloomKVStore(kvstore, 1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bloom.Close()
+	doc, err := NewDoc([]byte("new"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	err = bloom.Get(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc.Body()) != "val" {
+		t.Errorf("expected val, got %s", doc.Body())
+	}
+
+	// a write bypassing the filter is only seen after Rebuild
+	err = kvstore.SetKV([]byte("direct"), []byte("val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bloom.Rebuild()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bloom.GetKV([]byte("direct"))
+	if err != nil {
+		t.Error(err)
+	}
+}
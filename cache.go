@@ -0,0 +1,238 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"container/list"
+	"sync"
+)
+
+// approximate bookkeeping cost of a cache entry, counted against its size
+const cacheEntryOverhead = 64
+
+// CacheStats reports the activity of a CachedKVStore
+type CacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+	// Number of entries and bytes currently cached
+	Entries int
+	Bytes   int
+}
+
+type cacheEntry struct {
+	key    string
+	value  []byte
+	seqnum SeqNum
+}
+
+func (e *cacheEntry) size() int {
+	return len(e.key) + len(e.value) + cacheEntryOverhead
+}
+
+// CachedKVStore is a read-through LRU cache of values in front of a
+// KVStore, which avoids the cgo call and copy of GetKV for hot keys.
+// Writes through the CachedKVStore invalidate the keys they touch;
+// writes made to the KVStore directly must be followed by Invalidate or
+// Purge.  A CachedKVStore may be used from many goroutines at once,
+// since all access to the KVStore is serialized.  Cache hits do not wait
+// for a miss or write in progress on the KVStore.
+type CachedKVStore struct {
+	// serializes use of the KVStore handle; taken before mutex when
+	// both are needed
+	kvsMutex sync.Mutex
+	kvs      *KVStore
+	maxBytes int
+
+	mutex sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	stats CacheStats
+	// bumped by every invalidation, so a miss can tell whether the
+	// value it fetched may have been superseded meanwhile
+	invalidations uint64
+}
+
+// NewCachedKVStore creates a cache holding up to maxBytes of keys and
+// values read from kvs
+func NewCachedKVStore(kvs *KVStore, maxBytes int) *CachedKVStore {
+	return &CachedKVStore{
+		kvs:      kvs,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// KVStore returns the underlying KVStore
+func (c *CachedKVStore) KVStore() *KVStore {
+	return c.kvs
+}
+
+// GetKV returns the value for key, from the cache if possible
+func (c *CachedKVStore) GetKV(key []byte) ([]byte, error) {
+	c.mutex.Lock()
+	if elem, ok := c.items[string(key)]; ok {
+		c.stats.Hits++
+		c.lru.MoveToFront(elem)
+		rv := copyBytes(elem.Value.(*cacheEntry).value)
+		c.mutex.Unlock()
+		return rv, nil
+	}
+	c.stats.Misses++
+	invalidations := c.invalidations
+	c.mutex.Unlock()
+
+	doc, err := NewDoc(key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+	c.kvsMutex.Lock()
+	err = c.kvs.Get(doc)
+	c.kvsMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	value := doc.Body()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.invalidations != invalidations {
+		// a write may have landed after the fetch, don't cache it
+		return copyBytes(value), nil
+	}
+	if elem, ok := c.items[string(key)]; ok {
+		// another miss on the key cached it meanwhile, keep the newer
+		if elem.Value.(*cacheEntry).seqnum >= doc.SeqNum() {
+			return copyBytes(value), nil
+		}
+		c.remove(elem)
+	}
+	c.add(&cacheEntry{key: string(key), value: value, seqnum: doc.SeqNum()})
+	return copyBytes(value), nil
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	rv := make([]byte, len(b))
+	copy(rv, b)
+	return rv
+}
+
+// add inserts an entry, evicting the least recently used entries
+// to stay within maxBytes.  The caller must hold mutex.
+func (c *CachedKVStore) add(e *cacheEntry) {
+	if e.size() > c.maxBytes {
+		return
+	}
+	c.items[e.key] = c.lru.PushFront(e)
+	c.stats.Entries++
+	c.stats.Bytes += e.size()
+	for c.stats.Bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops an entry.  The caller must hold mutex.
+func (c *CachedKVStore) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.items, e.key)
+	c.stats.Entries--
+	c.stats.Bytes -= e.size()
+}
+
+// invalidate drops the entry for key, if any.  The caller must hold mutex.
+func (c *CachedKVStore) invalidate(key []byte) {
+	c.invalidations++
+	if elem, ok := c.items[string(key)]; ok {
+		c.remove(elem)
+		c.stats.Invalidations++
+	}
+}
+
+// SetKV stores the value for key and drops it from the cache
+func (c *CachedKVStore) SetKV(key, value []byte) error {
+	c.kvsMutex.Lock()
+	defer c.kvsMutex.Unlock()
+
+	err := c.kvs.SetKV(key, value)
+	c.Invalidate(key)
+	return err
+}
+
+// DeleteKV deletes key and drops it from the cache
+func (c *CachedKVStore) DeleteKV(key []byte) error {
+	c.kvsMutex.Lock()
+	defer c.kvsMutex.Unlock()
+
+	err := c.kvs.DeleteKV(key)
+	c.Invalidate(key)
+	return err
+}
+
+// Invalidate drops key from the cache, e.g. after writing it through
+// the underlying KVStore
+func (c *CachedKVStore) Invalidate(key []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.invalidate(key)
+}
+
+// Purge drops all entries from the cache
+func (c *CachedKVStore) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.invalidations++
+	c.stats.Invalidations += uint64(len(c.items))
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.stats.Entries = 0
+	c.stats.Bytes = 0
+}
+
+// Rollback rolls the KVStore back to sn and drops the cached values
+// written after it.  Values cached from before sn are still current,
+// as any later write through the cache would have invalidated them.
+func (c *CachedKVStore) Rollback(sn SeqNum) error {
+	c.kvsMutex.Lock()
+	defer c.kvsMutex.Unlock()
+
+	err := c.kvs.Rollback(sn)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.invalidations++
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*cacheEntry).seqnum > sn {
+			c.remove(elem)
+			c.stats.Invalidations++
+		}
+		elem = next
+	}
+	return nil
+}
+
+// Stats returns the cache metrics
+func (c *CachedKVStore) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
@@ -0,0 +1,158 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCachedKVStore(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	cache := NewCachedKVStore(kvstore, 1024)
+	err = cache.SetKV([]byte("key"), []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		val, err := cache.GetKV([]byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != "v1" {
+			t.Errorf("expected v1, got %s", val)
+		}
+	}
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	err = cache.SetKV([]byte("key"), []byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := cache.GetKV([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "v2" {
+		t.Errorf("expected v2, got %s", val)
+	}
+
+	err = cache.DeleteKV([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.GetKV([]byte("key"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+	if stats := cache.Stats(); stats.Invalidations != 2 {
+		t.Errorf("expected 2 invalidations, got %+v", stats)
+	}
+}
+
+func TestCachedKVStoreEviction(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// room for two entries
+	entrySize := 1 + 10 + cacheEntryOverhead
+	cache := NewCachedKVStore(kvstore, 2*entrySize)
+	for _, key := range []string{"a", "b", "c"} {
+		err = kvstore.SetKV([]byte(key), []byte("0123456789"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+		_, err = cache.GetKV([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "b" was evicted by "c", then "c" by "b"
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 4 || stats.Evictions != 2 || stats.Bytes != 2*entrySize {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCachedKVStoreRollback(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	cache := NewCachedKVStore(kvstore, 1024)
+	err = cache.SetKV([]byte("old"), []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := kvstore.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sn := info.LastSeqNum()
+
+	err = cache.SetKV([]byte("new"), []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"old", "new"} {
+		_, err = cache.GetKV([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = cache.Rollback(sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Entries != 1 {
+		t.Errorf("expected 1 entry after rollback, got %+v", stats)
+	}
+	_, err = cache.GetKV([]byte("new"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+	val, err := cache.GetKV([]byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "v1" {
+		t.Errorf("expected v1, got %s", val)
+	}
+}
@@ -0,0 +1,106 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+)
+
+var ErrConflict = fmt.Errorf("doc was modified concurrently")
+
+// GetWithSeq returns the value for key along with the sequence number of
+// its last update, for use with CompareAndSet and CompareAndDelete
+func (k *KVStore) GetWithSeq(key []byte) ([]byte, SeqNum, error) {
+	doc, err := NewDoc(key, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer doc.Close()
+
+	err = k.Get(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc.Body(), doc.SeqNum(), nil
+}
+
+// currentSeq returns the sequence number of the live doc for key,
+// or 0 if the key does not exist or has been deleted
+func (k *KVStore) currentSeq(key []byte) (SeqNum, error) {
+	doc, err := NewDoc(key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer doc.Close()
+
+	err = k.GetMetaOnly(doc)
+	if err == RESULT_KEY_NOT_FOUND {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if doc.Deleted() {
+		return 0, nil
+	}
+	return doc.SeqNum(), nil
+}
+
+// CompareAndSet stores value for key only if the key's sequence number is
+// still expectedSeq, as returned by GetWithSeq, and returns the new
+// sequence number.  An expectedSeq of 0 requires that the key does not
+// exist.  If the key has changed in the meantime ErrConflict is returned.
+// Compare-and-swap operations through the same KVStore are serialized;
+// plain writes, or writes through other handles, are still detected as
+// long as they happen before the check.
+func (k *KVStore) CompareAndSet(key []byte, expectedSeq SeqNum, value []byte) (SeqNum, error) {
+	k.writeMutex.Lock()
+	defer k.writeMutex.Unlock()
+
+	seq, err := k.currentSeq(key)
+	if err != nil {
+		return 0, err
+	}
+	if seq != expectedSeq {
+		return 0, ErrConflict
+	}
+
+	doc, err := NewDoc(key, nil, value)
+	if err != nil {
+		return 0, err
+	}
+	defer doc.Close()
+
+	err = k.Set(doc)
+	if err != nil {
+		return 0, err
+	}
+	return doc.SeqNum(), nil
+}
+
+// CompareAndDelete deletes key only if its sequence number is still
+// expectedSeq, returning ErrConflict otherwise.  Deleting with an
+// expectedSeq of 0 a key that does not exist is a no-op.
+func (k *KVStore) CompareAndDelete(key []byte, expectedSeq SeqNum) error {
+	k.writeMutex.Lock()
+	defer k.writeMutex.Unlock()
+
+	seq, err := k.currentSeq(key)
+	if err != nil {
+		return err
+	}
+	if seq != expectedSeq {
+		return ErrConflict
+	}
+	if seq == 0 {
+		return nil
+	}
+
+	return k.DeleteKV(key)
+}
@@ -0,0 +1,133 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// create only if absent
+	seq, err := kvstore.CompareAndSet([]byte("key"), 0, []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kvstore.CompareAndSet([]byte("key"), 0, []byte("v2"))
+	if err != ErrConflict {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	val, got, err := kvstore.GetWithSeq([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != seq || string(val) != "v1" {
+		t.Errorf("expected v1 at %d, got %s at %d", seq, val, got)
+	}
+
+	// a plain write invalidates the seqnum read before it
+	err = kvstore.SetKV([]byte("key"), []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kvstore.CompareAndSet([]byte("key"), seq, []byte("v2"))
+	if err != ErrConflict {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	err = kvstore.CompareAndDelete([]byte("key"), seq)
+	if err != ErrConflict {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	_, seq, err = kvstore.GetWithSeq([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.CompareAndDelete([]byte("key"), seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = kvstore.GetWithSeq([]byte("key"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+
+	// a deleted key can be recreated
+	_, err = kvstore.CompareAndSet([]byte("key"), 0, []byte("v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareAndSetConcurrent(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	_, err = kvstore.CompareAndSet([]byte("counter"), 0, []byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read-modify-write increments retried on conflict must not lose updates
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 25; {
+				// reads share the handle with the writers
+				mutex.Lock()
+				val, seq, err := kvstore.GetWithSeq([]byte("counter"))
+				mutex.Unlock()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				count, _ := strconv.Atoi(string(val))
+				mutex.Lock()
+				_, err = kvstore.CompareAndSet([]byte("counter"), seq, []byte(strconv.Itoa(count+1)))
+				mutex.Unlock()
+				if err == ErrConflict {
+					continue
+				} else if err != nil {
+					t.Error(err)
+					return
+				}
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, _, err := kvstore.GetWithSeq([]byte("counter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "100" {
+		t.Errorf("expected 100, got %s", val)
+	}
+}
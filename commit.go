@@ -20,6 +20,12 @@ const SnapshotInmem = 1<<64 - 1
 
 // SnapshotOpen creates an snapshot of a database file in ForestDB
 func (k *KVStore) SnapshotOpen(sn SeqNum) (*KVStore, error) {
+	if err := k.checkHandle(); err != nil {
+		return nil, err
+	}
+	if err := k.fault(FAULT_SNAPSHOT); err != nil {
+		return nil, err
+	}
 	rv := KVStore{}
 
 	Log.Tracef("fdb_snapshot_open call k:%p db:%p sn:%v", k, k.db, sn)
@@ -33,6 +39,12 @@ func (k *KVStore) SnapshotOpen(sn SeqNum) (*KVStore, error) {
 
 // Rollback a database to a specified point represented by the sequence number
 func (k *KVStore) Rollback(sn SeqNum) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_ROLLBACK); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_rollback call k:%p db:%p sn:%v", k, k.db, sn)
 	errNo := C.fdb_rollback(&k.db, C.fdb_seqnum_t(sn))
 	Log.Tracef("fdb_rollback retn k:%p errNo:%v db:%p", k, errNo, k.db)
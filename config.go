@@ -9,6 +9,7 @@ package forestdb
 //  either express or implied. See the License for the specific language governing permissions
 //  and limitations under the License.
 
+//#include <stdlib.h>
 //#include <libforestdb/forestdb.h>
 //fdb_compact_decision compaction_callback(fdb_file_handle *fhandle,
 //                                         fdb_compaction_status status,
@@ -20,6 +21,8 @@ package forestdb
 import "C"
 
 import (
+	"bytes"
+	"fmt"
 	"sync"
 	"unsafe"
 )
@@ -28,8 +31,8 @@ import (
 func CompactionCallbackInternal(handle *C.fdb_file_handle, status C.int, kv_store *C.char, document *C.fdb_doc,
 	last_oldfile_offset C.size_t, last_newfile_offset C.size_t, ctx unsafe.Pointer) C.fdb_compact_decision {
 
-	file := File{handle}
-	doc := Doc{document}
+	file := File{dbfile: handle}
+	doc := Doc{doc: document}
 	offset := (int)((uintptr)(unsafe.Pointer(ctx)))
 	decision := getCompactionCallback(offset).Callback(&file, CompactionStatus(status), C.GoString(kv_store),
 		&doc, uint64(last_oldfile_offset), uint64(last_newfile_offset))
@@ -116,9 +119,38 @@ const (
 	COMPACT_STATUS_COMPLETE   CompactionStatus = 0x20
 )
 
+type EncryptionAlgorithm int32
+
+const (
+	ENCRYPTION_NONE   EncryptionAlgorithm = 0
+	ENCRYPTION_AES256 EncryptionAlgorithm = 1
+	// Trivial, insecure encryption for testing only
+	ENCRYPTION_BOGUS EncryptionAlgorithm = -1
+)
+
+// Limits enforced by ForestDB on config values
+const (
+	MIN_CHUNK_SIZE            = 4
+	MAX_CHUNK_SIZE            = 64
+	MIN_BLOCK_SIZE            = 1024
+	MAX_BLOCK_SIZE            = 128 * 1024
+	MAX_COMPACTION_THRESHOLD  = 100
+	MAX_NUM_WAL_PARTITIONS    = 512
+	MAX_NUM_BCACHE_PARTITIONS = 512
+	MIN_MAX_WRITER_LOCK_PROB  = 20
+	MAX_MAX_WRITER_LOCK_PROB  = 100
+	MAX_NUM_COMPACTOR_THREADS = 128
+	MAX_NUM_BGFLUSHER_THREADS = 64
+	ENCRYPTION_KEY_SIZE       = 32
+)
+
 // ForestDB config options
 type Config struct {
 	config *C.fdb_config
+	// set by SetBreakpadMinidumpDir, and only passed to forestdb as a C
+	// string for the duration of Open and Init, see withBreakpadDir
+	breakpadDir    string
+	breakpadDirSet bool
 }
 
 func (c *Config) ChunkSize() uint16 {
@@ -289,12 +321,25 @@ func (c *Config) SetNumBcachePartitions(s uint16) {
 	c.config.num_bcache_partitions = C.uint16_t(s)
 }
 
+// CompactionCallback returns the callback set by SetCompactionCallback,
+// or nil if there is none
+func (c *Config) CompactionCallback() CompactionCallback {
+	if c.config.compaction_cb == nil {
+		return nil
+	}
+	return getCompactionCallback(int(uintptr(c.config.compaction_cb_ctx)))
+}
+
 func (c *Config) SetCompactionCallback(callback CompactionCallback) {
 	c.config.compaction_cb = (C.fdb_compaction_callback)(unsafe.Pointer(C.compaction_callback))
 	offset := (uintptr)(registerCompactionCallback(callback))
 	c.config.compaction_cb_ctx = unsafe.Pointer(offset)
 }
 
+func (c *Config) CompactionCallbackMask() CompactionStatus {
+	return CompactionStatus(c.config.compaction_cb_mask)
+}
+
 func (c *Config) SetCompactionCallbackMask(s CompactionStatus) {
 	c.config.compaction_cb_mask = C.uint32_t(s)
 }
@@ -323,7 +368,23 @@ func (c *Config) SetNumBgflusherThreads(s int) {
 	c.config.num_bgflusher_threads = C.size_t(s)
 }
 
-// TODO: encryption_key
+// EncryptionKey returns the algorithm and key used to encrypt the file
+func (c *Config) EncryptionKey() (EncryptionAlgorithm, [ENCRYPTION_KEY_SIZE]byte) {
+	var key [ENCRYPTION_KEY_SIZE]byte
+	for i := range key {
+		key[i] = byte(c.config.encryption_key.bytes[i])
+	}
+	return EncryptionAlgorithm(c.config.encryption_key.algorithm), key
+}
+
+// SetEncryptionKey encrypts the file with the given algorithm and key.
+// ENCRYPTION_NONE disables encryption.
+func (c *Config) SetEncryptionKey(algorithm EncryptionAlgorithm, key [ENCRYPTION_KEY_SIZE]byte) {
+	c.config.encryption_key.algorithm = C.fdb_encryption_algorithm_t(algorithm)
+	for i := range key {
+		c.config.encryption_key.bytes[i] = C.uint8_t(key[i])
+	}
+}
 
 func (c *Config) NumBlockReusingThreshold() int {
 	return int(c.config.block_reusing_threshold)
@@ -341,6 +402,175 @@ func (c *Config) SetNumKeepingHeaders(s int) {
 	c.config.num_keeping_headers = C.size_t(s)
 }
 
+func (c *Config) BreakpadMinidumpDir() string {
+	if c.breakpadDirSet {
+		return c.breakpadDir
+	}
+	if c.config.breakpad_minidump_dir == nil {
+		return ""
+	}
+	return C.GoString(c.config.breakpad_minidump_dir)
+}
+
+// SetBreakpadMinidumpDir sets the directory crash dumps are written to
+func (c *Config) SetBreakpadMinidumpDir(dir string) {
+	c.breakpadDir = dir
+	c.breakpadDirSet = true
+}
+
+// withBreakpadDir calls fn with the config pointing at a C copy of the
+// directory set by SetBreakpadMinidumpDir, which is freed afterwards
+func (c *Config) withBreakpadDir(fn func()) {
+	if !c.breakpadDirSet {
+		fn()
+		return
+	}
+	dir := C.CString(c.breakpadDir)
+	defer C.free(unsafe.Pointer(dir))
+	prev := c.config.breakpad_minidump_dir
+	c.config.breakpad_minidump_dir = dir
+	defer func() {
+		c.config.breakpad_minidump_dir = prev
+	}()
+	fn()
+}
+
+// ConfigError describes why a Config is invalid.  It is returned by
+// Validate, and so by Open and Init, and unwraps to RESULT_INVALID_CONFIG,
+// so errors.Is(err, RESULT_INVALID_CONFIG) reports it like the same
+// error from forestdb itself.
+type ConfigError struct {
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config: " + e.Reason
+}
+
+func (e *ConfigError) Unwrap() error {
+	return RESULT_INVALID_CONFIG
+}
+
+func invalidConfig(format string, args ...interface{}) error {
+	return &ConfigError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks the config against the limits enforced by ForestDB,
+// returning a *ConfigError describing the first violation found.  The
+// limits mirror those of libforestdb, which has the final say: Open and
+// Init only check the open flags up front, and call Validate to explain a
+// RESULT_INVALID_CONFIG returned by ForestDB.  Open flags other than
+// OPEN_FLAG_CREATE and OPEN_FLAG_RDONLY are left to ForestDB.
+func (c *Config) Validate() error {
+	if c.ChunkSize() < MIN_CHUNK_SIZE || c.ChunkSize() > MAX_CHUNK_SIZE {
+		return invalidConfig("chunk size %d outside [%d, %d]",
+			c.ChunkSize(), MIN_CHUNK_SIZE, MAX_CHUNK_SIZE)
+	}
+	if c.BlockSize() < MIN_BLOCK_SIZE || c.BlockSize() > MAX_BLOCK_SIZE {
+		return invalidConfig("block size %d outside [%d, %d]",
+			c.BlockSize(), MIN_BLOCK_SIZE, MAX_BLOCK_SIZE)
+	}
+	if c.SeqTreeOpt() != SEQTREE_NOT_USE && c.SeqTreeOpt() != SEQTREE_USE {
+		return invalidConfig("unknown seqtree option %d", c.SeqTreeOpt())
+	}
+	if c.DurabilityOpt() > DRB_ODIRECT_ASYNC {
+		return invalidConfig("unknown durability option %d", c.DurabilityOpt())
+	}
+	if c.CompactionMode() != COMPACT_MANUAL && c.CompactionMode() != COMPACT_AUTO {
+		return invalidConfig("unknown compaction mode %d", c.CompactionMode())
+	}
+	if err := c.validateOpenFlags(); err != nil {
+		return err
+	}
+	if c.CompactionThreshold() > MAX_COMPACTION_THRESHOLD {
+		return invalidConfig("compaction threshold %d%% above %d%%",
+			c.CompactionThreshold(), MAX_COMPACTION_THRESHOLD)
+	}
+	if c.CompactorSleepDuration() == 0 {
+		return invalidConfig("compactor sleep duration must be positive")
+	}
+	if c.NumWalPartitions() < 1 || c.NumWalPartitions() > MAX_NUM_WAL_PARTITIONS {
+		return invalidConfig("%d WAL partitions outside [1, %d]",
+			c.NumWalPartitions(), MAX_NUM_WAL_PARTITIONS)
+	}
+	if c.NumBcachePartitions() < 1 || c.NumBcachePartitions() > MAX_NUM_BCACHE_PARTITIONS {
+		return invalidConfig("%d buffer cache partitions outside [1, %d]",
+			c.NumBcachePartitions(), MAX_NUM_BCACHE_PARTITIONS)
+	}
+	if c.MaxWriterLockProb() < MIN_MAX_WRITER_LOCK_PROB || c.MaxWriterLockProb() > MAX_MAX_WRITER_LOCK_PROB {
+		return invalidConfig("max writer lock probability %d%% outside [%d%%, %d%%]",
+			c.MaxWriterLockProb(), MIN_MAX_WRITER_LOCK_PROB, MAX_MAX_WRITER_LOCK_PROB)
+	}
+	if c.NumCompactorThreads() < 1 || c.NumCompactorThreads() > MAX_NUM_COMPACTOR_THREADS {
+		return invalidConfig("%d compactor threads outside [1, %d]",
+			c.NumCompactorThreads(), MAX_NUM_COMPACTOR_THREADS)
+	}
+	if c.NumBgflusherThreads() > MAX_NUM_BGFLUSHER_THREADS {
+		return invalidConfig("%d background flusher threads above %d",
+			c.NumBgflusherThreads(), MAX_NUM_BGFLUSHER_THREADS)
+	}
+	if c.NumKeepingHeaders() < 1 {
+		return invalidConfig("number of keeping headers must be positive")
+	}
+	algorithm, _ := c.EncryptionKey()
+	if algorithm != ENCRYPTION_NONE && algorithm != ENCRYPTION_AES256 && algorithm != ENCRYPTION_BOGUS {
+		return invalidConfig("unknown encryption algorithm %d", algorithm)
+	}
+	return nil
+}
+
+// validateOpenFlags checks that the open flags do not contradict each
+// other or the compaction mode
+func (c *Config) validateOpenFlags() error {
+	flags := c.OpenFlags()
+	if flags&OPEN_FLAG_CREATE != 0 && flags&OPEN_FLAG_RDONLY != 0 {
+		return invalidConfig("OPEN_FLAG_CREATE and OPEN_FLAG_RDONLY are exclusive")
+	}
+	if c.CompactionMode() == COMPACT_AUTO && flags&OPEN_FLAG_RDONLY != 0 {
+		return invalidConfig("COMPACT_AUTO requires a writable file, but OPEN_FLAG_RDONLY is set")
+	}
+	return nil
+}
+
+// explain replaces a RESULT_INVALID_CONFIG from ForestDB with the
+// *ConfigError of Validate, if it finds the cause
+func (c *Config) explain(err error) error {
+	if err == RESULT_INVALID_CONFIG {
+		if verr := c.Validate(); verr != nil {
+			return verr
+		}
+	}
+	return err
+}
+
+// String dumps all settings for diagnostics.  The encryption key itself
+// is not included.
+func (c *Config) String() string {
+	buf := &bytes.Buffer{}
+	algorithm, _ := c.EncryptionKey()
+	callback := "<nil>"
+	if cb := c.CompactionCallback(); cb != nil {
+		callback = cb.Name()
+	}
+	fmt.Fprintf(buf, "Config{ChunkSize:%d BlockSize:%d BufferCacheSize:%d WalThreshold:%d "+
+		"WalFlushBeforeCommit:%v AutoCommit:%v PurgingInterval:%d SeqTreeOpt:%d DurabilityOpt:%d "+
+		"OpenFlags:%#x CompactionBufferSizeMax:%d CleanupCacheOnClose:%v CompressDocumentBody:%v "+
+		"CompactionMode:%d CompactionThreshold:%d CompactionMinimumFilesize:%d "+
+		"CompactorSleepDuration:%d MultiKVInstances:%v PrefetchDuration:%d NumWalPartitions:%d "+
+		"NumBcachePartitions:%d CompactionCallback:%s CompactionCallbackMask:%#x "+
+		"MaxWriterLockProb:%d NumCompactorThreads:%d NumBgflusherThreads:%d EncryptionAlgorithm:%d "+
+		"NumBlockReusingThreshold:%d NumKeepingHeaders:%d BreakpadMinidumpDir:%q}",
+		c.ChunkSize(), c.BlockSize(), c.BufferCacheSize(), c.WalThreshold(),
+		c.WalFlushBeforeCommit(), c.AutoCommit(), c.PurgingInterval(), c.SeqTreeOpt(), c.DurabilityOpt(),
+		c.OpenFlags(), c.CompactionBufferSizeMax(), c.CleanupCacheOnClose(), c.CompressDocumentBody(),
+		c.CompactionMode(), c.CompactionThreshold(), c.CompactionMinimumFilesize(),
+		c.CompactorSleepDuration(), c.MultiKVInstances(), c.PrefetchDuration(), c.NumWalPartitions(),
+		c.NumBcachePartitions(), callback, c.CompactionCallbackMask(),
+		c.MaxWriterLockProb(), c.NumCompactorThreads(), c.NumBgflusherThreads(), algorithm,
+		c.NumBlockReusingThreshold(), c.NumKeepingHeaders(), c.BreakpadMinidumpDir())
+	return buf.String()
+}
+
 // DefaultConfig gets the default ForestDB config
 func DefaultConfig() *Config {
 	Log.Debugf("fdb_get_default_config call")
@@ -0,0 +1,132 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	err := DefaultConfig().Validate()
+	if err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+
+	// flags unknown to this package, such as FDB_OPEN_WITH_LEGACY_CRC,
+	// are left to forestdb
+	config := DefaultConfig()
+	config.SetOpenFlags(OPEN_FLAG_CREATE | OpenFlags(0x04))
+	err = config.Validate()
+	if err != nil {
+		t.Errorf("unknown open flag: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		substr string
+	}{
+		{"chunk size", func(c *Config) { c.SetChunkSize(2) }, "chunk size"},
+		{"block size", func(c *Config) { c.SetBlockSize(512) }, "block size"},
+		{"durability", func(c *Config) { c.SetDurabilityOpt(DurabilityOpt(7)) }, "durability"},
+		{"create and read only", func(c *Config) {
+			c.SetOpenFlags(OPEN_FLAG_CREATE | OPEN_FLAG_RDONLY)
+		}, "exclusive"},
+		{"auto compaction read only", func(c *Config) {
+			c.SetOpenFlags(OPEN_FLAG_RDONLY)
+			c.SetCompactionMode(COMPACT_AUTO)
+		}, "COMPACT_AUTO"},
+		{"compaction threshold", func(c *Config) { c.SetCompactionThreshold(101) }, "compaction threshold"},
+		{"wal partitions", func(c *Config) { c.SetNumWalPartitions(MAX_NUM_WAL_PARTITIONS + 1) }, "WAL partitions"},
+		{"bcache partitions", func(c *Config) { c.SetNumBcachePartitions(0) }, "buffer cache partitions"},
+		{"writer lock prob", func(c *Config) { c.SetMaxWriterLockProb(10) }, "writer lock"},
+		{"compactor threads", func(c *Config) { c.SetNumCompactorThreads(0) }, "compactor threads"},
+		{"keeping headers", func(c *Config) { c.SetNumKeepingHeaders(0) }, "keeping headers"},
+		{"encryption", func(c *Config) {
+			c.SetEncryptionKey(EncryptionAlgorithm(42), [ENCRYPTION_KEY_SIZE]byte{})
+		}, "encryption"},
+	}
+	for _, test := range tests {
+		config := DefaultConfig()
+		test.modify(config)
+		err := config.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.substr) {
+			t.Errorf("%s: expected error about %q, got %v", test.name, test.substr, err)
+		}
+	}
+}
+
+func TestConfigOpenInvalid(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	config := DefaultConfig()
+	config.SetNumWalPartitions(0)
+	_, err := Open("test", config)
+	if err == nil || !strings.Contains(err.Error(), "WAL partitions") {
+		t.Errorf("expected error about WAL partitions, got %v", err)
+	}
+}
+
+func TestConfigOpenInvalidResult(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	config := DefaultConfig()
+	config.SetOpenFlags(OPEN_FLAG_RDONLY)
+	config.SetCompactionMode(COMPACT_AUTO)
+	_, err := Open("test", config)
+	if !errors.Is(err, RESULT_INVALID_CONFIG) {
+		t.Errorf("expected %v, got %v", RESULT_INVALID_CONFIG, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "COMPACT_AUTO") {
+		t.Errorf("expected error about COMPACT_AUTO, got %v", err)
+	}
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Errorf("expected a *ConfigError, got %T", err)
+	}
+}
+
+func TestConfigAccessors(t *testing.T) {
+	config := DefaultConfig()
+
+	var key [ENCRYPTION_KEY_SIZE]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	config.SetEncryptionKey(ENCRYPTION_AES256, key)
+	algorithm, got := config.EncryptionKey()
+	if algorithm != ENCRYPTION_AES256 || got != key {
+		t.Errorf("expected encryption key %v %v, got %v %v", ENCRYPTION_AES256, key, algorithm, got)
+	}
+
+	config.SetBreakpadMinidumpDir("dumps")
+	config.SetBreakpadMinidumpDir("minidumps")
+	if config.BreakpadMinidumpDir() != "minidumps" {
+		t.Errorf("expected breakpad dir minidumps, got %q", config.BreakpadMinidumpDir())
+	}
+
+	config.SetCompactionCallbackMask(COMPACT_STATUS_BEGIN | COMPACT_STATUS_END)
+	if config.CompactionCallbackMask() != COMPACT_STATUS_BEGIN|COMPACT_STATUS_END {
+		t.Errorf("unexpected compaction callback mask %#x", config.CompactionCallbackMask())
+	}
+
+	s := config.String()
+	for _, substr := range []string{"ChunkSize:", "EncryptionAlgorithm:1", `BreakpadMinidumpDir:"minidumps"`} {
+		if !strings.Contains(s, substr) {
+			t.Errorf("expected %q in %s", substr, s)
+		}
+	}
+	if strings.Contains(s, "[0 1 2") {
+		t.Errorf("encryption key leaked in %s", s)
+	}
+}
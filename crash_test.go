@@ -0,0 +1,368 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The crash harness runs crashWorkload in a child process (this test
+// binary re-executed with TestCrashChild selected), kills it with SIGKILL
+// at a random point, reopens the file and checks that the recovered state
+// is exactly that of one complete commit.
+//
+// Every commit overwrites the same crashKeys keys and the key "batch"
+// with the batch number, so batch b ends at seqnum b*(crashKeys+1).  The
+// child appends a line to a log, synced to disk, after each step:
+//
+//	commit <batch>      after a successful Commit
+//	compact <file>      before compacting into file
+//	file <file>         after the compaction completed
+const (
+	crashDir          = "crashtest"
+	crashKeys         = 20
+	crashCompactEvery = 25
+	crashRounds       = 8
+)
+
+func crashConfig(durability DurabilityOpt, walFlush bool) *Config {
+	config := DefaultConfig()
+	config.SetDurabilityOpt(durability)
+	config.SetWalFlushBeforeCommit(walFlush)
+	return config
+}
+
+// TestCrashChild runs the workload when invoked by TestCrashRecovery
+func TestCrashChild(t *testing.T) {
+	if os.Getenv("FDB_CRASH_CHILD") == "" {
+		t.Skip("only run as a child of TestCrashRecovery")
+	}
+	durability, _ := strconv.Atoi(os.Getenv("FDB_CRASH_DURABILITY"))
+	walFlush := os.Getenv("FDB_CRASH_WALFLUSH") == "1"
+	err := crashWorkload(crashConfig(DurabilityOpt(durability), walFlush))
+	// the workload only ends by being killed
+	t.Fatal(err)
+}
+
+func crashWorkload(config *Config) error {
+	log, err := os.OpenFile(filepath.Join(crashDir, "log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	logf := func(format string, args ...interface{}) error {
+		_, err := fmt.Fprintf(log, format+"\n", args...)
+		if err != nil {
+			return err
+		}
+		return log.Sync()
+	}
+
+	name := filepath.Join(crashDir, "db.0")
+	dbfile, err := Open(name, config)
+	if err != nil {
+		return err
+	}
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		return err
+	}
+
+	for batch := 1; ; batch++ {
+		value := []byte(strconv.Itoa(batch))
+		for i := 0; i < crashKeys; i++ {
+			err = kvstore.SetKV([]byte(fmt.Sprintf("key-%d", i)), value)
+			if err != nil {
+				return err
+			}
+		}
+		err = kvstore.SetKV([]byte("batch"), value)
+		if err != nil {
+			return err
+		}
+		err = dbfile.Commit(COMMIT_NORMAL)
+		if err != nil {
+			return err
+		}
+		err = logf("commit %d", batch)
+		if err != nil {
+			return err
+		}
+
+		if batch%crashCompactEvery == 0 {
+			name = filepath.Join(crashDir, fmt.Sprintf("db.%d", batch))
+			err = logf("compact %s", name)
+			if err != nil {
+				return err
+			}
+			err = dbfile.Compact(name)
+			if err != nil {
+				return err
+			}
+			err = logf("file %s", name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// crashLog is what the child recorded before being killed
+type crashLog struct {
+	lastCommit int
+	file       string
+	compacting string
+}
+
+func readCrashLog() (*crashLog, error) {
+	f, err := os.Open(filepath.Join(crashDir, "log"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rv := &crashLog{file: filepath.Join(crashDir, "db.0")}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			// a line torn by the kill
+			continue
+		}
+		switch fields[0] {
+		case "commit":
+			rv.lastCommit, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, err
+			}
+		case "compact":
+			rv.compacting = fields[1]
+		case "file":
+			rv.file = fields[1]
+			rv.compacting = ""
+		}
+	}
+	return rv, scanner.Err()
+}
+
+func TestCrashRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping crash recovery in short mode")
+	}
+
+	configs := []struct {
+		durability DurabilityOpt
+		walFlush   bool
+	}{
+		{DRB_NONE, false},
+		{DRB_NONE, true},
+		{DRB_ASYNC, false},
+		{DRB_ODIRECT, true},
+	}
+	for _, c := range configs {
+		for round := 0; round < crashRounds; round++ {
+			// kill at random, or just after a compaction started
+			atCompaction := round%2 == 1
+			delay := time.Duration(rand.Intn(200)) * time.Millisecond
+			if atCompaction {
+				delay = time.Duration(rand.Intn(5000)) * time.Microsecond
+			}
+			err := runCrashRound(t, c.durability, c.walFlush, atCompaction, delay)
+			if err != nil {
+				t.Fatalf("durability %v, wal flush %v, round %d: %v",
+					c.durability, c.walFlush, round, err)
+			}
+		}
+	}
+}
+
+func runCrashRound(t *testing.T, durability DurabilityOpt, walFlush bool, atCompaction bool, delay time.Duration) error {
+	os.RemoveAll(crashDir)
+	defer os.RemoveAll(crashDir)
+	err := os.Mkdir(crashDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	walFlushEnv := "0"
+	if walFlush {
+		walFlushEnv = "1"
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCrashChild$")
+	cmd.Env = append(os.Environ(),
+		"FDB_CRASH_CHILD=1",
+		fmt.Sprintf("FDB_CRASH_DURABILITY=%d", durability),
+		"FDB_CRASH_WALFLUSH="+walFlushEnv)
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	// wait for the workload to get going, and for a compaction to
+	// start if requested
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("workload exited before being killed: %v", err)
+		case <-time.After(time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			<-exited
+			return fmt.Errorf("timed out waiting for the workload")
+		}
+		log, err := readCrashLog()
+		if err != nil || log.lastCommit == 0 {
+			continue
+		}
+		if !atCompaction || log.compacting != "" {
+			break
+		}
+	}
+	time.Sleep(delay)
+	err = cmd.Process.Kill()
+	if err != nil {
+		return err
+	}
+	<-exited
+
+	log, err := readCrashLog()
+	if err != nil {
+		return err
+	}
+	return verifyCrashRecovery(t, log, crashConfig(durability, walFlush))
+}
+
+func verifyCrashRecovery(t *testing.T, log *crashLog, config *Config) error {
+	// a compaction interrupted before completing leaves the old file in
+	// place, while a completed one removes it
+	name := log.file
+	if _, err := os.Stat(name); os.IsNotExist(err) && log.compacting != "" {
+		name = log.compacting
+	}
+	dbfile, err := Open(name, config)
+	if err != nil {
+		return fmt.Errorf("reopening %s: %v", name, err)
+	}
+	defer dbfile.Close()
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		return err
+	}
+	defer kvstore.Close()
+
+	batch, err := crashBatch(kvstore)
+	if err != nil {
+		return err
+	}
+	// every logged commit survives, plus at most the one whose log line
+	// had not been written yet
+	if batch < log.lastCommit || batch > log.lastCommit+1 {
+		return fmt.Errorf("recovered batch %d, last logged commit %d", batch, log.lastCommit)
+	}
+	err = checkCrashBatch(kvstore, batch)
+	if err != nil {
+		return err
+	}
+	info, err := kvstore.Info()
+	if err != nil {
+		return err
+	}
+	lastSeq := info.LastSeqNum()
+	if lastSeq != SeqNum(batch*(crashKeys+1)) {
+		return fmt.Errorf("recovered batch %d, but last seqnum %d", batch, lastSeq)
+	}
+
+	// every snapshot marker is a complete commit, the newest first
+	markers, err := dbfile.GetAllSnapMarkers()
+	if err != nil {
+		return err
+	}
+	defer markers.FreeSnapMarkers()
+	prev := lastSeq + 1
+	for i, info := range markers.SnapInfoList() {
+		cm := info.GetKvsCommitMarkers()
+		if len(cm) != 1 {
+			return fmt.Errorf("marker %d: expected 1 kvs commit marker, got %d", i, len(cm))
+		}
+		seq := cm[0].GetSeqNum()
+		if i == 0 && seq != lastSeq {
+			return fmt.Errorf("newest marker at seqnum %d, expected %d", seq, lastSeq)
+		}
+		if seq >= prev || seq%(crashKeys+1) != 0 {
+			return fmt.Errorf("marker %d at unexpected seqnum %d", i, seq)
+		}
+		prev = seq
+		if seq == 0 {
+			continue
+		}
+		snap, err := kvstore.SnapshotOpen(seq)
+		if err != nil {
+			return fmt.Errorf("opening snapshot at marker seqnum %d: %v", seq, err)
+		}
+		err = checkCrashBatch(snap, int(seq)/(crashKeys+1))
+		snap.Close()
+		if err != nil {
+			return fmt.Errorf("snapshot at seqnum %d: %v", seq, err)
+		}
+	}
+	t.Logf("recovered batch %d from %s with %d snapshot markers",
+		batch, name, len(markers.SnapInfoList()))
+	return nil
+}
+
+// crashBatch returns the number of the batch committed last, 0 if none
+func crashBatch(kvstore *KVStore) (int, error) {
+	value, err := kvstore.GetKV([]byte("batch"))
+	if err == RESULT_KEY_NOT_FOUND {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(value))
+}
+
+// checkCrashBatch verifies that exactly the writes of batch are visible
+func checkCrashBatch(kvstore *KVStore, batch int) error {
+	got, err := crashBatch(kvstore)
+	if err != nil {
+		return err
+	}
+	if got != batch {
+		return fmt.Errorf("expected batch %d, got %d", batch, got)
+	}
+	for i := 0; i < crashKeys; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		value, err := kvstore.GetKV(key)
+		if batch == 0 && err == RESULT_KEY_NOT_FOUND {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("key %s: %v", key, err)
+		}
+		if string(value) != strconv.Itoa(batch) {
+			return fmt.Errorf("key %s: expected batch %d, got %s", key, batch, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// Cursor captures a position within a key range scan so that the scan
+// can be resumed later, for example by a client paging through an HTTP
+// API.  A Cursor can be encoded as an opaque token with Encode.
+type Cursor struct {
+	StartKey []byte
+	EndKey   []byte
+	Opt      IteratorOpt
+	Reverse  bool
+	// If non-zero, pages are read from the snapshot at this committed
+	// sequence number, so that they are consistent with each other.
+	// Page sets it to the last committed sequence number when reading
+	// the first page, unless the KVStore has not been committed yet.
+	SeqNum SeqNum
+	// The key of the last doc returned, nil before the first page
+	LastKey []byte
+}
+
+var InvalidCursor = fmt.Errorf("invalid or tampered cursor token")
+
+const cursorVersion = 1
+
+const (
+	cursorReverse = 1 << iota
+	cursorHasStart
+	cursorHasEnd
+	cursorHasLast
+)
+
+// NewCursor creates a Cursor positioned before the first doc of the range
+func NewCursor(startKey, endKey []byte, opt IteratorOpt, reverse bool) *Cursor {
+	return &Cursor{
+		StartKey: startKey,
+		EndKey:   endKey,
+		Opt:      opt,
+		Reverse:  reverse,
+	}
+}
+
+func cursorMAC(payload, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Encode returns the cursor as a URL-safe token authenticated with secret
+func (c *Cursor) Encode(secret []byte) string {
+	var flags byte
+	if c.Reverse {
+		flags |= cursorReverse
+	}
+	if c.StartKey != nil {
+		flags |= cursorHasStart
+	}
+	if c.EndKey != nil {
+		flags |= cursorHasEnd
+	}
+	if c.LastKey != nil {
+		flags |= cursorHasLast
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(cursorVersion)
+	buf.WriteByte(flags)
+	binary.Write(buf, binary.BigEndian, uint16(c.Opt))
+	binary.Write(buf, binary.BigEndian, uint64(c.SeqNum))
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, key := range [][]byte{c.StartKey, c.EndKey, c.LastKey} {
+		n := binary.PutUvarint(lenBuf[:], uint64(len(key)))
+		buf.Write(lenBuf[:n])
+		buf.Write(key)
+	}
+	payload := buf.Bytes()
+	return base64.RawURLEncoding.EncodeToString(append(payload, cursorMAC(payload, secret)...))
+}
+
+// DecodeCursor parses a token produced by Cursor.Encode with the same
+// secret, returning InvalidCursor if it is malformed or was modified.
+func DecodeCursor(token string, secret []byte) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < sha256.Size {
+		return nil, InvalidCursor
+	}
+	payload := raw[:len(raw)-sha256.Size]
+	if !hmac.Equal(raw[len(payload):], cursorMAC(payload, secret)) {
+		return nil, InvalidCursor
+	}
+
+	r := bytes.NewReader(payload)
+	var version, flags byte
+	var opt uint16
+	var seqnum uint64
+	if binary.Read(r, binary.BigEndian, &version) != nil || version != cursorVersion ||
+		binary.Read(r, binary.BigEndian, &flags) != nil ||
+		binary.Read(r, binary.BigEndian, &opt) != nil ||
+		binary.Read(r, binary.BigEndian, &seqnum) != nil {
+		return nil, InvalidCursor
+	}
+	keys := make([][]byte, 3)
+	for i := range keys {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return nil, InvalidCursor
+		}
+		keys[i] = make([]byte, l)
+		r.Read(keys[i])
+	}
+
+	rv := &Cursor{
+		Opt:     IteratorOpt(opt),
+		Reverse: flags&cursorReverse != 0,
+		SeqNum:  SeqNum(seqnum),
+	}
+	if flags&cursorHasStart != 0 {
+		rv.StartKey = keys[0]
+	}
+	if flags&cursorHasEnd != 0 {
+		rv.EndKey = keys[1]
+	}
+	if flags&cursorHasLast != 0 {
+		rv.LastKey = keys[2]
+	}
+	return rv, nil
+}
+
+// Page returns up to limit docs following the cursor position and a
+// cursor positioned after the last of them, or a nil cursor if the range
+// has been exhausted.  The scan resumes by seeking to the last key
+// returned, so docs inserted or deleted behind the cursor do not shift
+// later pages.  The first page is read from the last commit, whose
+// sequence number the returned cursor records, so writes made between
+// pages do not show up in later ones.
+func (k *KVStore) Page(c *Cursor, limit int) ([]*Doc, *Cursor, error) {
+	seqnum := c.SeqNum
+	if seqnum == 0 && c.LastKey == nil && k.f != nil {
+		var err error
+		seqnum, err = k.committedSeqNum()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	kvs := k
+	if seqnum != 0 {
+		snap, err := k.SnapshotOpen(seqnum)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer snap.Close()
+		kvs = snap
+	}
+
+	iter, err := kvs.IteratorInit(c.StartKey, c.EndKey, c.Opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+
+	move := iter.Next
+	if c.Reverse {
+		move = iter.Prev
+	}
+
+	// position the iterator at the first doc of the page
+	if c.LastKey == nil {
+		if c.Reverse {
+			err = iter.SeekMax()
+		}
+	} else {
+		dir := FDB_ITR_SEEK_HIGHER
+		if c.Reverse {
+			dir = FDB_ITR_SEEK_LOWER
+		}
+		err = iter.Seek(c.LastKey, dir)
+		if err == nil {
+			var doc *Doc
+			doc, err = iter.GetMetaOnly()
+			if err == nil {
+				if bytes.Equal(doc.Key(), c.LastKey) {
+					err = move()
+				}
+				doc.Close()
+			}
+		}
+	}
+	if isIterEnd(err) {
+		// nothing left in the range
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var docs []*Doc
+	for limit <= 0 || len(docs) < limit {
+		doc, err := iter.Get()
+		if isIterEnd(err) {
+			return docs, nil, nil
+		} else if err != nil {
+			for _, doc := range docs {
+				doc.Close()
+			}
+			return nil, nil, err
+		}
+		docs = append(docs, doc)
+		if move() != nil {
+			return docs, nil, nil
+		}
+	}
+
+	next := *c
+	next.SeqNum = seqnum
+	next.LastKey = docs[len(docs)-1].Key()
+	return docs, &next, nil
+}
+
+// committedSeqNum returns the sequence number of the KVStore at the last
+// commit of its File, 0 if there is none
+func (k *KVStore) committedSeqNum() (SeqNum, error) {
+	markers, err := k.f.GetAllSnapMarkers()
+	if err == RESULT_NO_DB_HEADERS {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer markers.FreeSnapMarkers()
+
+	list := markers.SnapInfoList()
+	if len(list) == 0 {
+		return 0, nil
+	}
+	for _, cm := range list[0].GetKvsCommitMarkers() {
+		// the marker of the default KVStore may be unnamed
+		name := cm.GetKvStoreName()
+		if name == k.name || (name == "" && k.name == "default") {
+			return cm.GetSeqNum(), nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,157 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCursorEncodeDecode(t *testing.T) {
+	secret := []byte("secret")
+	c := NewCursor([]byte("a"), nil, ITR_NO_DELETES, true)
+	c.SeqNum = 42
+	c.LastKey = []byte{}
+
+	token := c.Encode(secret)
+	d, err := DecodeCursor(token, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, d) {
+		t.Errorf("expected %+v, got %+v", c, d)
+	}
+
+	_, err = DecodeCursor(token, []byte("other secret"))
+	if err != InvalidCursor {
+		t.Errorf("expected %v, got %v", InvalidCursor, err)
+	}
+
+	tampered := []byte(token)
+	tampered[3] ^= 1
+	_, err = DecodeCursor(string(tampered), secret)
+	if err != InvalidCursor {
+		t.Errorf("expected %v, got %v", InvalidCursor, err)
+	}
+
+	_, err = DecodeCursor("", secret)
+	if err != InvalidCursor {
+		t.Errorf("expected %v, got %v", InvalidCursor, err)
+	}
+}
+
+func TestKVStorePage(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	secret := []byte("secret")
+	for _, reverse := range []bool{false, true} {
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			err = kvstore.SetKV([]byte(key), []byte("val"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		token := NewCursor(nil, nil, ITR_NO_DELETES, reverse).Encode(secret)
+		var all []string
+		for token != "" {
+			c, err := DecodeCursor(token, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs, next, err := kvstore.Page(c, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, doc := range docs {
+				all = append(all, string(doc.Key()))
+				doc.Close()
+			}
+			token = ""
+			if next != nil {
+				token = next.Encode(secret)
+				// the key last returned disappears between pages
+				kvstore.DeleteKV(next.LastKey)
+			}
+		}
+		expected := []string{"a", "b", "c", "d", "e"}
+		if reverse {
+			expected = []string{"e", "d", "c", "b", "a"}
+		}
+		if !reflect.DeepEqual(all, expected) {
+			t.Errorf("reverse %v: expected %q, got %q", reverse, expected, all)
+		}
+	}
+}
+
+func TestKVStorePageSnapshot(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for _, key := range []string{"a", "c", "e"} {
+		err = kvstore.SetKV([]byte(key), []byte("val"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, next, err := kvstore.Page(NewCursor(nil, nil, ITR_NO_DELETES, false), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range docs {
+		doc.Close()
+	}
+	if next == nil || next.SeqNum != 3 {
+		t.Fatalf("expected a cursor at seqnum 3, got %+v", next)
+	}
+
+	// a committed write between pages is not seen by the next one
+	err = kvstore.SetKV([]byte("d"), []byte("val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, next, err = kvstore.Page(next, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, doc := range docs {
+		keys = append(keys, string(doc.Key()))
+		doc.Close()
+	}
+	if !reflect.DeepEqual(keys, []string{"c", "e"}) {
+		t.Errorf("expected [c e], got %q", keys)
+	}
+	if next != nil {
+		t.Errorf("expected the range to be exhausted, got %+v", next)
+	}
+}
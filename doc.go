@@ -9,6 +9,7 @@ package forestdb
 //  either express or implied. See the License for the specific language governing permissions
 //  and limitations under the License.
 
+//#include <stdlib.h>
 //#include <libforestdb/forestdb.h>
 import "C"
 
@@ -20,7 +21,8 @@ type SeqNum uint64
 
 // ForestDB doc structure definition
 type Doc struct {
-	doc *C.fdb_doc
+	doc  *C.fdb_doc
+	view bool
 }
 
 // NewDoc creates a new FDB_DOC instance on heap with a given key, its metadata, and its doc body
@@ -79,19 +81,51 @@ func (d *Doc) Update(meta, body []byte) error {
 	return nil
 }
 
+// SetView switches the document in or out of view mode.  In view mode
+// Key, Meta and Body return slices aliasing the C memory of the document
+// instead of copies.  These slices are only valid until the document is
+// refilled (for example by the next Iterator.GetView or DocPool reuse)
+// or closed, and must not be modified.
+func (d *Doc) SetView(view bool) {
+	d.view = view
+}
+
+// View returns whether or not this document is in view mode
+func (d *Doc) View() bool {
+	return d.view
+}
+
+func (d *Doc) bytes(p unsafe.Pointer, l C.size_t) []byte {
+	if !d.view {
+		return C.GoBytes(p, C.int(l))
+	}
+	return goBytesNoCopy(p, l)
+}
+
 // Key returns the document key
 func (d *Doc) Key() []byte {
-	return C.GoBytes(d.doc.key, C.int(d.doc.keylen))
+	return d.bytes(d.doc.key, d.doc.keylen)
 }
 
 // Meta returns the document metadata
 func (d *Doc) Meta() []byte {
-	return C.GoBytes(d.doc.meta, C.int(d.doc.metalen))
+	return d.bytes(d.doc.meta, d.doc.metalen)
 }
 
 // Body returns the document body
 func (d *Doc) Body() []byte {
-	return C.GoBytes(d.doc.body, C.int(d.doc.bodylen))
+	return d.bytes(d.doc.body, d.doc.bodylen)
+}
+
+// BodyLen returns the length of the document body, which is also
+// known for documents retrieved with the metadata only
+func (d *Doc) BodyLen() int {
+	return int(d.doc.bodylen)
+}
+
+// size returns the combined length of the key, metadata and body
+func (d *Doc) size() uint64 {
+	return uint64(d.doc.keylen) + uint64(d.doc.metalen) + uint64(d.doc.bodylen)
 }
 
 // SeqNum returns the document sequence number
@@ -115,6 +149,31 @@ func (d *Doc) Deleted() bool {
 	return bool(d.doc.deleted)
 }
 
+// reset releases the key, metadata and body buffers owned by the
+// document so that the underlying fdb_doc can be refilled by forestdb
+// without overflowing the previous allocations.
+func (d *Doc) reset() {
+	if d.doc.key != nil {
+		C.free(d.doc.key)
+		d.doc.key = nil
+	}
+	if d.doc.meta != nil {
+		C.free(d.doc.meta)
+		d.doc.meta = nil
+	}
+	if d.doc.body != nil {
+		C.free(d.doc.body)
+		d.doc.body = nil
+	}
+	d.doc.keylen = 0
+	d.doc.metalen = 0
+	d.doc.bodylen = 0
+	d.doc.size_ondisk = 0
+	d.doc.seqnum = 0
+	d.doc.offset = 0
+	d.doc.deleted = false
+}
+
 // Close releases resources allocated to this document
 func (d *Doc) Close() error {
 	Log.Tracef("fdb_doc_free call d:%p doc:%v", d, d.doc)
@@ -0,0 +1,131 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Datatype describes the encoding of a doc body, as in Couchbase
+type Datatype uint8
+
+const (
+	DATATYPE_RAW    Datatype = 0x00
+	DATATYPE_JSON   Datatype = 0x01
+	DATATYPE_SNAPPY Datatype = 0x02
+	DATATYPE_XATTR  Datatype = 0x04
+)
+
+// DocMeta is a structured layout for doc metadata, mirroring the
+// metadata Couchbase keeps for each document
+type DocMeta struct {
+	CAS      uint64
+	Revision uint64
+	Flags    uint32
+	// Expiry time in seconds since the Unix epoch, 0 for none
+	Expiry   uint32
+	Datatype Datatype
+	// Application specific metadata
+	User []byte
+}
+
+var InvalidDocMeta = fmt.Errorf("invalid doc metadata")
+
+// The encoding starts with a version byte and the length of the fixed
+// fields, so that later versions can append fields which older readers
+// skip over.  The user fields follow the fixed fields.
+const (
+	docMetaVersion   = 1
+	docMetaHeaderLen = 2 + 8 + 8 + 4 + 4 + 1
+)
+
+// Encode returns the metadata in its stored form
+func (m *DocMeta) Encode() []byte {
+	rv := make([]byte, docMetaHeaderLen, docMetaHeaderLen+len(m.User))
+	rv[0] = docMetaVersion
+	rv[1] = docMetaHeaderLen
+	binary.BigEndian.PutUint64(rv[2:], m.CAS)
+	binary.BigEndian.PutUint64(rv[10:], m.Revision)
+	binary.BigEndian.PutUint32(rv[18:], m.Flags)
+	binary.BigEndian.PutUint32(rv[22:], m.Expiry)
+	rv[26] = byte(m.Datatype)
+	return append(rv, m.User...)
+}
+
+// DecodeDocMeta parses metadata produced by DocMeta.Encode
+func DecodeDocMeta(b []byte) (*DocMeta, error) {
+	if len(b) < 2 || b[0] < docMetaVersion || int(b[1]) < docMetaHeaderLen || len(b) < int(b[1]) {
+		return nil, InvalidDocMeta
+	}
+	rv := DocMeta{
+		CAS:      binary.BigEndian.Uint64(b[2:]),
+		Revision: binary.BigEndian.Uint64(b[10:]),
+		Flags:    binary.BigEndian.Uint32(b[18:]),
+		Expiry:   binary.BigEndian.Uint32(b[22:]),
+		Datatype: Datatype(b[26]),
+	}
+	if user := b[b[1]:]; len(user) > 0 {
+		rv.User = make([]byte, len(user))
+		copy(rv.User, user)
+	}
+	return &rv, nil
+}
+
+// DocMeta decodes the metadata of the doc, e.g. one returned by
+// Iterator.GetMetaOnly
+func (d *Doc) DocMeta() (*DocMeta, error) {
+	return DecodeDocMeta(d.Meta())
+}
+
+// SetWithMeta stores the value for key along with its structured metadata
+func (k *KVStore) SetWithMeta(key []byte, meta *DocMeta, value []byte) error {
+	doc, err := NewDoc(key, meta.Encode(), value)
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
+	return k.Set(doc)
+}
+
+// GetWithMeta returns the structured metadata and value for key
+func (k *KVStore) GetWithMeta(key []byte) (*DocMeta, []byte, error) {
+	doc, err := NewDoc(key, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer doc.Close()
+
+	err = k.Get(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := doc.DocMeta()
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, doc.Body(), nil
+}
+
+// GetDocMeta returns the structured metadata for key without reading
+// the doc body
+func (k *KVStore) GetDocMeta(key []byte) (*DocMeta, error) {
+	doc, err := NewDoc(key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+
+	err = k.GetMetaOnly(doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc.DocMeta()
+}
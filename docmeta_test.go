@@ -0,0 +1,115 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDocMetaCodec(t *testing.T) {
+	meta := DocMeta{
+		CAS:      0x1234567890,
+		Revision: 7,
+		Flags:    0xdeadbeef,
+		Expiry:   1500000000,
+		Datatype: DATATYPE_JSON | DATATYPE_SNAPPY,
+		User:     []byte("user"),
+	}
+	decoded, err := DecodeDocMeta(meta.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decoded, meta) {
+		t.Errorf("expected %+v, got %+v", meta, *decoded)
+	}
+
+	// fields appended by a later version are skipped
+	future := meta.Encode()[:docMetaHeaderLen]
+	future[0] = docMetaVersion + 1
+	future[1] = docMetaHeaderLen + 4
+	future = append(future, 1, 2, 3, 4)
+	future = append(future, meta.User...)
+	decoded, err = DecodeDocMeta(future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decoded, meta) {
+		t.Errorf("expected %+v, got %+v", meta, *decoded)
+	}
+
+	for _, b := range [][]byte{nil, {docMetaVersion}, {0, docMetaHeaderLen}, meta.Encode()[:docMetaHeaderLen-1]} {
+		_, err = DecodeDocMeta(b)
+		if err != InvalidDocMeta {
+			t.Errorf("%x: expected InvalidDocMeta, got %v", b, err)
+		}
+	}
+}
+
+func TestSetWithMeta(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	meta := DocMeta{CAS: 1, Revision: 1, Datatype: DATATYPE_JSON}
+	err = kvstore.SetWithMeta([]byte("key"), &meta, []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, val, err := kvstore.GetWithMeta([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, meta) || string(val) != `{"a":1}` {
+		t.Errorf("expected %+v %s, got %+v %s", meta, `{"a":1}`, *got, val)
+	}
+
+	got, err = kvstore.GetDocMeta([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, meta) {
+		t.Errorf("expected %+v, got %+v", meta, *got)
+	}
+
+	iter, err := kvstore.IteratorInit(nil, nil, ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	doc, err := iter.GetMetaOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	got, err = doc.DocMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, meta) {
+		t.Errorf("expected %+v, got %+v", meta, *got)
+	}
+
+	// a plain SetKV has no structured metadata
+	err = kvstore.SetKV([]byte("plain"), []byte("val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kvstore.GetDocMeta([]byte("plain"))
+	if err != InvalidDocMeta {
+		t.Errorf("expected InvalidDocMeta, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DocPool is a pool of reusable Docs.  Docs hold C memory, so unlike
+// sync.Pool the pool never drops them silently; Docs beyond the
+// configured size are closed when returned and the rest are closed
+// by Close.
+type DocPool struct {
+	m      sync.Mutex
+	closed bool
+	size   int
+	view   bool
+	docs   []*Doc
+}
+
+var DocPoolClosed = fmt.Errorf("doc pool already closed")
+
+// NewDocPool creates a pool retaining at most size Docs.  If view is
+// true the Docs handed out are in view mode (see Doc.SetView).
+func NewDocPool(size int, view bool) *DocPool {
+	return &DocPool{
+		size: size,
+		view: view,
+		docs: make([]*Doc, 0, size),
+	}
+}
+
+// Get returns an empty Doc from the pool, allocating a new one
+// if the pool is empty.
+func (p *DocPool) Get() (*Doc, error) {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil, DocPoolClosed
+	}
+	if n := len(p.docs); n > 0 {
+		rv := p.docs[n-1]
+		p.docs = p.docs[:n-1]
+		p.m.Unlock()
+		return rv, nil
+	}
+	p.m.Unlock()
+
+	rv, err := NewDoc(nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	rv.SetView(p.view)
+	return rv, nil
+}
+
+// Put releases the buffers held by the Doc and returns it to the pool.
+// The Doc, and any view slices obtained from it, must not be used
+// afterwards.
+func (p *DocPool) Put(doc *Doc) error {
+	doc.reset()
+	p.m.Lock()
+	if p.closed || len(p.docs) >= p.size {
+		p.m.Unlock()
+		return doc.Close()
+	}
+	doc.SetView(p.view)
+	p.docs = append(p.docs, doc)
+	p.m.Unlock()
+	return nil
+}
+
+// Close frees all Docs currently held by the pool.
+func (p *DocPool) Close() (rverr error) {
+	p.m.Lock()
+	docs := p.docs
+	p.docs = nil
+	p.closed = true
+	p.m.Unlock()
+
+	for _, doc := range docs {
+		err := doc.Close()
+		if err != nil && rverr == nil {
+			rverr = err
+		}
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDocPoolIterator(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	for i := 0; i < 10; i++ {
+		// vary the body size to make sure buffers are not reused blindly
+		body := make([]byte, i*100+1)
+		body[0] = byte(i)
+		err = kvstore.SetKV([]byte(fmt.Sprintf("key%d", i)), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := NewDocPool(1, true)
+	defer pool.Close()
+
+	iter, err := kvstore.IteratorInit(nil, nil, ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	count := 0
+	for {
+		doc, err := pool.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = iter.GetReuse(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(doc.Key()) != fmt.Sprintf("key%d", count) {
+			t.Errorf("expected key%d, got %s", count, doc.Key())
+		}
+		if len(doc.Body()) != count*100+1 || doc.Body()[0] != byte(count) {
+			t.Errorf("unexpected body for key%d", count)
+		}
+		err = pool.Put(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if iter.Next() != nil {
+			break
+		}
+	}
+	if count != 10 {
+		t.Errorf("expected to iterate 10, saw %d", count)
+	}
+
+	err = pool.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.Get()
+	if err != DocPoolClosed {
+		t.Errorf("expected %v, got %v", DocPoolClosed, err)
+	}
+}
@@ -0,0 +1,80 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// number of docs sampled by EstimateRange
+const estimateRangeSamples = 256
+
+// EstimateRange returns the approximate number of non-deleted docs between
+// startKey and endKey (inclusive) and their combined key, metadata and
+// body size, without scanning the range.  Docs are sampled by looking up
+// the metadata of randomly chosen sequence numbers, and the estimate is
+// scaled by the KVStore's doc count.  The sequence index must be enabled,
+// otherwise RESULT_INVALID_CONFIG is returned; use CountRange instead.
+// Should no live doc be found that way, as when nearly all sequence
+// numbers were superseded by updates, the range is counted with
+// CountRange instead.
+func (k *KVStore) EstimateRange(startKey, endKey []byte) (docs, bytes uint64, err error) {
+	info, err := k.Info()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var sampled, inRange, inRangeBytes uint64
+	err = k.sampleBySeq(estimateRangeSamples, func(doc *Doc) {
+		sampled++
+		if keyInRange(doc.Key(), startKey, endKey) {
+			inRange++
+			inRangeBytes += doc.size()
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if sampled == 0 {
+		if info.DocCount() == 0 {
+			return 0, 0, nil
+		}
+		return k.CountRange(startKey, endKey)
+	}
+	if inRange == 0 {
+		return 0, 0, nil
+	}
+
+	docs = info.DocCount() * inRange / sampled
+	bytes = docs * inRangeBytes / inRange
+	return docs, bytes, nil
+}
+
+// CountRange returns the exact number of non-deleted docs between startKey
+// and endKey (inclusive) and their combined key, metadata and body size.
+// It scans the range but reads only the metadata of each doc.
+func (k *KVStore) CountRange(startKey, endKey []byte) (docs, bytes uint64, err error) {
+	iter, err := k.IteratorInit(startKey, endKey, ITR_NO_DELETES)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer iter.Close()
+
+	for {
+		doc, err := iter.GetMetaOnly()
+		if isIterEnd(err) {
+			return docs, bytes, nil
+		} else if err != nil {
+			return 0, 0, err
+		}
+		docs++
+		bytes += doc.size()
+		doc.Close()
+		if iter.Next() != nil {
+			return docs, bytes, nil
+		}
+	}
+}
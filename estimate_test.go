@@ -0,0 +1,131 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCountEstimateRange(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// 1000 docs of 7 byte keys and 93 byte bodies
+	body := make([]byte, 93)
+	for i := 0; i < 1000; i++ {
+		err = kvstore.SetKV([]byte(fmt.Sprintf("key%04d", i)), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = kvstore.DeleteKV([]byte("key0000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, bytes, err := kvstore.CountRange([]byte("key0000"), []byte("key0499"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs != 499 || bytes != 499*100 {
+		t.Errorf("expected 499 docs of 49900 bytes, got %d docs of %d bytes", docs, bytes)
+	}
+
+	docs, bytes, err = kvstore.EstimateRange([]byte("key0000"), []byte("key0499"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sampling error makes the estimate approximate
+	if docs < 300 || docs > 700 {
+		t.Errorf("expected about 500 docs, estimated %d", docs)
+	}
+	if bytes != docs*100 {
+		t.Errorf("expected %d bytes, estimated %d", docs*100, bytes)
+	}
+
+	docs, _, err = kvstore.EstimateRange([]byte("zzz"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs != 0 {
+		t.Errorf("expected no docs, estimated %d", docs)
+	}
+}
+
+func TestEstimateRangeAfterUpdates(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// 100 docs rewritten 50 times, leaving 1 in 50 sequence numbers live
+	body := make([]byte, 93)
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 100; i++ {
+			err = kvstore.SetKV([]byte(fmt.Sprintf("key%04d", i)), body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, bytes, err := kvstore.EstimateRange([]byte("key0000"), []byte("key0049"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs < 30 || docs > 70 {
+		t.Errorf("expected about 50 docs, estimated %d", docs)
+	}
+	if bytes != docs*100 {
+		t.Errorf("expected %d bytes, estimated %d", docs*100, bytes)
+	}
+}
+
+func TestEstimateRangeNoSeqTree(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	config := DefaultConfig()
+	config.SetSeqTreeOpt(SEQTREE_NOT_USE)
+	kvstore, err := OpenFileKVStore("test", config, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for i := 0; i < 100; i++ {
+		err = kvstore.SetKV([]byte(fmt.Sprintf("key%04d", i)), []byte("val"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, err = kvstore.EstimateRange(nil, nil)
+	if err != RESULT_INVALID_CONFIG {
+		t.Errorf("expected %v, got %v", RESULT_INVALID_CONFIG, err)
+	}
+}
@@ -0,0 +1,82 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// FaultOp identifies an operation at which a FaultFunc can inject a failure
+type FaultOp uint8
+
+const (
+	// Get, GetMetaOnly, GetBySeq, GetMetaOnlyBySeq, GetByOffset, GetKV
+	// and GetInto
+	FAULT_GET FaultOp = iota
+	// Set and SetKV, and every set within ExecuteBatch
+	FAULT_SET
+	// Delete and DeleteKV, and every delete within ExecuteBatch
+	FAULT_DELETE
+	// IteratorInit and IteratorSequenceInit
+	FAULT_ITERATOR_INIT
+	FAULT_BEGIN_TRANSACTION
+	FAULT_END_TRANSACTION
+	FAULT_ABORT_TRANSACTION
+	FAULT_COMMIT
+	// Compact and CompactUpto
+	FAULT_COMPACT
+	FAULT_SNAPSHOT
+	FAULT_ROLLBACK
+)
+
+var faultOpNames = []string{
+	FAULT_GET:               "get",
+	FAULT_SET:               "set",
+	FAULT_DELETE:            "delete",
+	FAULT_ITERATOR_INIT:     "iterator init",
+	FAULT_BEGIN_TRANSACTION: "begin transaction",
+	FAULT_END_TRANSACTION:   "end transaction",
+	FAULT_ABORT_TRANSACTION: "abort transaction",
+	FAULT_COMMIT:            "commit",
+	FAULT_COMPACT:           "compact",
+	FAULT_SNAPSHOT:          "snapshot",
+	FAULT_ROLLBACK:          "rollback",
+}
+
+func (op FaultOp) String() string {
+	if int(op) < len(faultOpNames) {
+		return faultOpNames[op]
+	}
+	return "unknown"
+}
+
+// FaultFunc is called before ForestDB performs op.  If it returns an
+// error, the operation is not performed and fails with that error.
+// It may also sleep to inject latency.
+type FaultFunc func(op FaultOp) error
+
+// SetFaultFunc installs fn to be called before the operations of the
+// File and of the KVStores opened from it, including those performed
+// internally by helpers such as ExecuteBatch.  Snapshots are not
+// affected.  It is meant for testing error paths, see package faultfdb,
+// and must be called before the File is used concurrently.  A nil fn
+// removes the hook.
+func (f *File) SetFaultFunc(fn FaultFunc) {
+	f.faultFunc = fn
+}
+
+// fault returns the error injected for op, if any
+func (f *File) fault(op FaultOp) error {
+	if f == nil || f.faultFunc == nil {
+		return nil
+	}
+	return f.faultFunc(op)
+}
+
+// fault returns the error injected for op, if any
+func (k *KVStore) fault(op FaultOp) error {
+	return k.f.fault(op)
+}
@@ -0,0 +1,404 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// Package faultfdb injects failures and latency into ForestDB operations,
+// so that retry and abort paths can be tested on demand.
+//
+// An Injector attached to a File fails operations inside the forestdb
+// package itself, including the writes and commits ExecuteBatch performs,
+// so its abort path can be exercised:
+//
+//	inj := faultfdb.NewInjector(1)
+//	inj.Attach(kvstore.File())
+//	inj.Inject(faultfdb.Fault{Op: faultfdb.OpSet, After: 2, Times: 1, Err: forestdb.RESULT_WRITE_FAIL})
+//
+// A Store wraps any store.Store, such as a store.MemStore, and fails
+// calls before they reach it.
+package faultfdb
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/couchbase/goforestdb"
+	"github.com/couchbase/goforestdb/store"
+)
+
+// Op identifies a class of operations
+type Op string
+
+const (
+	// Any operation
+	OpAny Op = ""
+	// Gets of any kind
+	OpGet Op = "get"
+	// Set and SetKV, also within ExecuteBatch
+	OpSet Op = "set"
+	// Delete and DeleteKV, also within ExecuteBatch
+	OpDelete Op = "delete"
+	// Creating an iterator, and every iterator call of a Store
+	OpIterate Op = "iterate"
+	// ExecuteBatch of a Store
+	OpBatch            Op = "batch"
+	OpBeginTransaction Op = "begin"
+	OpEndTransaction   Op = "end"
+	OpAbortTransaction Op = "abort"
+	OpCommit           Op = "commit"
+	// Compact and CompactUpto of an attached File
+	OpCompact  Op = "compact"
+	OpSnapshot Op = "snapshot"
+	OpRollback Op = "rollback"
+)
+
+var fileOps = map[forestdb.FaultOp]Op{
+	forestdb.FAULT_GET:               OpGet,
+	forestdb.FAULT_SET:               OpSet,
+	forestdb.FAULT_DELETE:            OpDelete,
+	forestdb.FAULT_ITERATOR_INIT:     OpIterate,
+	forestdb.FAULT_BEGIN_TRANSACTION: OpBeginTransaction,
+	forestdb.FAULT_END_TRANSACTION:   OpEndTransaction,
+	forestdb.FAULT_ABORT_TRANSACTION: OpAbortTransaction,
+	forestdb.FAULT_COMMIT:            OpCommit,
+	forestdb.FAULT_COMPACT:           OpCompact,
+	forestdb.FAULT_SNAPSHOT:          OpSnapshot,
+	forestdb.FAULT_ROLLBACK:          OpRollback,
+}
+
+// Fault describes when an operation fails or is delayed.  Of the calls
+// matching Op, the first After are left alone; the following calls are
+// affected with the given Probability (0 meaning always), at most Times
+// times (0 meaning unlimited).
+type Fault struct {
+	Op          Op
+	After       int
+	Times       int
+	Probability float64
+	// Delay applied to affected calls
+	Latency time.Duration
+	// Error returned by affected calls, such as RESULT_WRITE_FAIL,
+	// RESULT_FSYNC_FAIL, RESULT_HANDLE_BUSY, RESULT_CHECKSUM_ERROR or
+	// RESULT_COMMIT_FAIL: a forestdb.Error for an attached File, a
+	// store.Error for a Store.  If nil, calls are only delayed.
+	Err error
+}
+
+type rule struct {
+	Fault
+	calls int
+	fired int
+}
+
+// Injector holds faults and decides which calls they affect
+type Injector struct {
+	mutex sync.Mutex
+	rules []*rule
+	calls map[Op]int
+	rand  *rand.Rand
+}
+
+// NewInjector creates an Injector without faults.  seed makes
+// probabilistic faults reproducible.
+func NewInjector(seed int64) *Injector {
+	return &Injector{
+		calls: make(map[Op]int),
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Attach makes the Injector fail the operations of file and of the
+// KVStores opened from it.  See File.SetFaultFunc.
+func (i *Injector) Attach(file *forestdb.File) {
+	file.SetFaultFunc(func(op forestdb.FaultOp) error {
+		return i.check(fileOps[op])
+	})
+}
+
+// Wrap returns a Store injecting the faults of i into s
+func (i *Injector) Wrap(s store.Store) *Store {
+	return &Store{s: s, Injector: i}
+}
+
+// Inject adds a fault.  If several faults affect a call, their
+// latencies add up and the error of the first one added is returned.
+func (i *Injector) Inject(fault Fault) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.rules = append(i.rules, &rule{Fault: fault})
+}
+
+// Clear removes all faults
+func (i *Injector) Clear() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.rules = nil
+}
+
+// Calls returns the number of calls of op made so far, including
+// failed ones
+func (i *Injector) Calls(op Op) int {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.calls[op]
+}
+
+// check records a call of op and returns the error to fail it with
+func (i *Injector) check(op Op) error {
+	i.mutex.Lock()
+	i.calls[op]++
+	var latency time.Duration
+	var err error
+	for _, r := range i.rules {
+		if r.Op != OpAny && r.Op != op {
+			continue
+		}
+		r.calls++
+		if r.calls <= r.After || (r.Times > 0 && r.fired >= r.Times) {
+			continue
+		}
+		if r.Probability > 0 && i.rand.Float64() >= r.Probability {
+			continue
+		}
+		r.fired++
+		latency += r.Latency
+		if err == nil {
+			err = r.Err
+		}
+	}
+	i.mutex.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return err
+}
+
+// Store is a store.Store injecting faults into a wrapped store.  A
+// KVStore should be faulted by attaching an Injector to its File
+// rather than by wrapping KVStore.AsStore, which would only fail calls
+// before they reach the forestdb package.
+type Store struct {
+	*Injector
+	s store.Store
+}
+
+// NewStore wraps s with a new Injector
+func NewStore(s store.Store, seed int64) *Store {
+	return NewInjector(seed).Wrap(s)
+}
+
+// Unwrap returns the wrapped store
+func (f *Store) Unwrap() store.Store {
+	return f.s
+}
+
+func (f *Store) Get(doc *store.Doc) error {
+	if err := f.check(OpGet); err != nil {
+		return err
+	}
+	return f.s.Get(doc)
+}
+
+func (f *Store) GetMetaOnly(doc *store.Doc) error {
+	if err := f.check(OpGet); err != nil {
+		return err
+	}
+	return f.s.GetMetaOnly(doc)
+}
+
+func (f *Store) GetBySeq(doc *store.Doc) error {
+	if err := f.check(OpGet); err != nil {
+		return err
+	}
+	return f.s.GetBySeq(doc)
+}
+
+func (f *Store) Set(doc *store.Doc) error {
+	if err := f.check(OpSet); err != nil {
+		return err
+	}
+	return f.s.Set(doc)
+}
+
+func (f *Store) Delete(doc *store.Doc) error {
+	if err := f.check(OpDelete); err != nil {
+		return err
+	}
+	return f.s.Delete(doc)
+}
+
+func (f *Store) GetKV(key []byte) ([]byte, error) {
+	if err := f.check(OpGet); err != nil {
+		return nil, err
+	}
+	return f.s.GetKV(key)
+}
+
+func (f *Store) SetKV(key, value []byte) error {
+	if err := f.check(OpSet); err != nil {
+		return err
+	}
+	return f.s.SetKV(key, value)
+}
+
+func (f *Store) DeleteKV(key []byte) error {
+	if err := f.check(OpDelete); err != nil {
+		return err
+	}
+	return f.s.DeleteKV(key)
+}
+
+func (f *Store) IteratorInit(startKey, endKey []byte, opt store.IteratorOpt) (store.Iterator, error) {
+	if err := f.check(OpIterate); err != nil {
+		return nil, err
+	}
+	iter, err := f.s.IteratorInit(startKey, endKey, opt)
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{iter: iter, inj: f.Injector}, nil
+}
+
+func (f *Store) IteratorSequenceInit(startSeq, endSeq store.SeqNum, opt store.IteratorOpt) (store.Iterator, error) {
+	if err := f.check(OpIterate); err != nil {
+		return nil, err
+	}
+	iter, err := f.s.IteratorSequenceInit(startSeq, endSeq, opt)
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{iter: iter, inj: f.Injector}, nil
+}
+
+func (f *Store) NewBatch() store.Batch {
+	return f.s.NewBatch()
+}
+
+// ExecuteBatch passes the batch through to the wrapped store, unless
+// the batch as a whole fails
+func (f *Store) ExecuteBatch(b store.Batch, opt store.CommitOpt) error {
+	if err := f.check(OpBatch); err != nil {
+		return err
+	}
+	return f.s.ExecuteBatch(b, opt)
+}
+
+func (f *Store) BeginTransaction() error {
+	if err := f.check(OpBeginTransaction); err != nil {
+		return err
+	}
+	return f.s.BeginTransaction()
+}
+
+func (f *Store) EndTransaction(opt store.CommitOpt) error {
+	if err := f.check(OpEndTransaction); err != nil {
+		return err
+	}
+	return f.s.EndTransaction(opt)
+}
+
+func (f *Store) AbortTransaction() error {
+	if err := f.check(OpAbortTransaction); err != nil {
+		return err
+	}
+	return f.s.AbortTransaction()
+}
+
+func (f *Store) Commit(opt store.CommitOpt) error {
+	if err := f.check(OpCommit); err != nil {
+		return err
+	}
+	return f.s.Commit(opt)
+}
+
+// SnapshotOpen opens a snapshot of the wrapped store, which shares
+// the faults of this Store
+func (f *Store) SnapshotOpen(sn store.SeqNum) (store.Store, error) {
+	if err := f.check(OpSnapshot); err != nil {
+		return nil, err
+	}
+	snap, err := f.s.SnapshotOpen(sn)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Injector: f.Injector, s: snap}, nil
+}
+
+func (f *Store) Rollback(sn store.SeqNum) error {
+	if err := f.check(OpRollback); err != nil {
+		return err
+	}
+	return f.s.Rollback(sn)
+}
+
+func (f *Store) LastSeqNum() (store.SeqNum, error) {
+	return f.s.LastSeqNum()
+}
+
+func (f *Store) Close() error {
+	return f.s.Close()
+}
+
+type iterator struct {
+	iter store.Iterator
+	inj  *Injector
+}
+
+func (i *iterator) Get() (*store.Doc, error) {
+	if err := i.inj.check(OpIterate); err != nil {
+		return nil, err
+	}
+	return i.iter.Get()
+}
+
+func (i *iterator) GetMetaOnly() (*store.Doc, error) {
+	if err := i.inj.check(OpIterate); err != nil {
+		return nil, err
+	}
+	return i.iter.GetMetaOnly()
+}
+
+func (i *iterator) Next() error {
+	if err := i.inj.check(OpIterate); err != nil {
+		return err
+	}
+	return i.iter.Next()
+}
+
+func (i *iterator) Prev() error {
+	if err := i.inj.check(OpIterate); err != nil {
+		return err
+	}
+	return i.iter.Prev()
+}
+
+func (i *iterator) Seek(key []byte, dir store.SeekOpt) error {
+	if err := i.inj.check(OpIterate); err != nil {
+		return err
+	}
+	return i.iter.Seek(key, dir)
+}
+
+func (i *iterator) SeekMin() error {
+	if err := i.inj.check(OpIterate); err != nil {
+		return err
+	}
+	return i.iter.SeekMin()
+}
+
+func (i *iterator) SeekMax() error {
+	if err := i.inj.check(OpIterate); err != nil {
+		return err
+	}
+	return i.iter.SeekMax()
+}
+
+func (i *iterator) Close() error {
+	return i.iter.Close()
+}
@@ -0,0 +1,178 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package faultfdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/couchbase/goforestdb"
+	"github.com/couchbase/goforestdb/store"
+	"github.com/couchbase/goforestdb/store/storetest"
+)
+
+func TestPassThrough(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Store, func()) {
+		s := NewStore(store.NewMemStore(), 1)
+		return s, func() { s.Close() }
+	})
+}
+
+func TestFailByCount(t *testing.T) {
+	s := NewStore(store.NewMemStore(), 1)
+	s.Inject(Fault{Op: OpSet, After: 2, Times: 1, Err: store.RESULT_WRITE_FAIL})
+
+	var errs []error
+	for i := 0; i < 4; i++ {
+		errs = append(errs, s.SetKV([]byte{byte('a' + i)}, []byte("val")))
+	}
+	expected := []error{nil, nil, store.RESULT_WRITE_FAIL, nil}
+	for i := range errs {
+		if errs[i] != expected[i] {
+			t.Errorf("call %d: expected %v, got %v", i, expected[i], errs[i])
+		}
+	}
+	// the failed write never reached the store
+	_, err := s.GetKV([]byte("c"))
+	if err != store.RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", store.RESULT_KEY_NOT_FOUND, err)
+	}
+	if s.Calls(OpSet) != 4 {
+		t.Errorf("expected 4 set calls, got %d", s.Calls(OpSet))
+	}
+}
+
+func TestFailByProbability(t *testing.T) {
+	s := NewStore(store.NewMemStore(), 1)
+	s.Inject(Fault{Op: OpGet, Probability: 0.5, Err: store.RESULT_CHECKSUM_ERROR})
+
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		_, err := s.GetKV([]byte("key"))
+		if err == store.RESULT_CHECKSUM_ERROR {
+			failed++
+		} else if err != store.RESULT_KEY_NOT_FOUND {
+			t.Fatal(err)
+		}
+	}
+	if failed < 400 || failed > 600 {
+		t.Errorf("expected about 500 failures, got %d", failed)
+	}
+
+	s.Clear()
+	_, err := s.GetKV([]byte("key"))
+	if err != store.RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", store.RESULT_KEY_NOT_FOUND, err)
+	}
+}
+
+func TestBatchFail(t *testing.T) {
+	s := NewStore(store.NewMemStore(), 1)
+	s.Inject(Fault{Op: OpBatch, Times: 1, Err: store.RESULT_HANDLE_BUSY})
+
+	b := s.NewBatch()
+	b.Set([]byte("a"), []byte("val"))
+	err := s.ExecuteBatch(b, store.COMMIT_NORMAL)
+	if err != store.RESULT_HANDLE_BUSY {
+		t.Errorf("expected %v, got %v", store.RESULT_HANDLE_BUSY, err)
+	}
+	_, err = s.GetKV([]byte("a"))
+	if err != store.RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", store.RESULT_KEY_NOT_FOUND, err)
+	}
+
+	err = s.ExecuteBatch(b, store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetKV([]byte("a"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileExecuteBatchAbort(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := forestdb.OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forestdb.CloseFileKVStore(kvstore)
+
+	inj := NewInjector(1)
+	inj.Attach(kvstore.File())
+	inj.Inject(Fault{Op: OpDelete, Times: 1, Err: forestdb.RESULT_HANDLE_BUSY})
+
+	batch := forestdb.NewKVBatch()
+	defer batch.Reset()
+	batch.Set([]byte("a"), []byte("val"))
+	batch.Delete([]byte("b"))
+	err = kvstore.ExecuteBatch(batch, forestdb.COMMIT_NORMAL)
+	if err != forestdb.RESULT_HANDLE_BUSY {
+		t.Errorf("expected %v, got %v", forestdb.RESULT_HANDLE_BUSY, err)
+	}
+	if inj.Calls(OpAbortTransaction) != 1 || inj.Calls(OpEndTransaction) != 0 {
+		t.Errorf("expected the transaction to be aborted")
+	}
+	// the set preceding the failure was rolled back with the transaction
+	_, err = kvstore.GetKV([]byte("a"))
+	if err != forestdb.RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", forestdb.RESULT_KEY_NOT_FOUND, err)
+	}
+}
+
+func TestFileCompactFail(t *testing.T) {
+	defer os.RemoveAll("test")
+	defer os.RemoveAll("test-compacted")
+
+	kvstore, err := forestdb.OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forestdb.CloseFileKVStore(kvstore)
+
+	inj := NewInjector(1)
+	inj.Attach(kvstore.File())
+	inj.Inject(Fault{Op: OpCommit, Times: 1, Err: forestdb.RESULT_FSYNC_FAIL})
+	inj.Inject(Fault{Op: OpCompact, Err: forestdb.RESULT_WRITE_FAIL})
+
+	err = kvstore.SetKV([]byte("a"), []byte("val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Commit(forestdb.COMMIT_NORMAL)
+	if err != forestdb.RESULT_FSYNC_FAIL {
+		t.Errorf("expected %v, got %v", forestdb.RESULT_FSYNC_FAIL, err)
+	}
+	err = kvstore.File().Commit(forestdb.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Compact("test-compacted")
+	if err != forestdb.RESULT_WRITE_FAIL {
+		t.Errorf("expected %v, got %v", forestdb.RESULT_WRITE_FAIL, err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	s := NewStore(store.NewMemStore(), 1)
+	s.Inject(Fault{Op: OpCommit, Latency: 20 * time.Millisecond})
+
+	start := time.Now()
+	err := s.Commit(store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Errorf("expected commit to be delayed")
+	}
+}
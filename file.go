@@ -20,7 +20,8 @@ import (
 
 // Database handle
 type File struct {
-	dbfile *C.fdb_file_handle
+	dbfile    *C.fdb_file_handle
+	faultFunc FaultFunc
 }
 
 // Init initializes forestdb library
@@ -28,10 +29,17 @@ func Init(config *Config) error {
 	if config == nil {
 		config = DefaultConfig()
 	}
+	err := config.validateOpenFlags()
+	if err != nil {
+		return err
+	}
 
-	errNo := C.fdb_init(config.config)
+	var errNo C.fdb_status
+	config.withBreakpadDir(func() {
+		errNo = C.fdb_init(config.config)
+	})
 	if errNo != RESULT_SUCCESS {
-		return Error(errNo)
+		return config.explain(Error(errNo))
 	}
 	return nil
 }
@@ -42,16 +50,23 @@ func Open(filename string, config *Config) (*File, error) {
 	if config == nil {
 		config = DefaultConfig()
 	}
+	err := config.validateOpenFlags()
+	if err != nil {
+		return nil, err
+	}
 
 	dbname := C.CString(filename)
 	defer C.free(unsafe.Pointer(dbname))
 
 	rv := File{}
-	Log.Tracef("fdb_open call rv:%p dbname:%v conf:%v", &rv, dbname, config.config)
-	errNo := C.fdb_open(&rv.dbfile, dbname, config.config)
-	Log.Tracef("fdb_open ret rv:%p errNo:%v rv:%v", &rv, errNo, rv)
+	var errNo C.fdb_status
+	config.withBreakpadDir(func() {
+		Log.Tracef("fdb_open call rv:%p dbname:%v conf:%v", &rv, dbname, config.config)
+		errNo = C.fdb_open(&rv.dbfile, dbname, config.config)
+		Log.Tracef("fdb_open ret rv:%p errNo:%v rv:%v", &rv, errNo, rv)
+	})
 	if errNo != RESULT_SUCCESS {
-		return nil, Error(errNo)
+		return nil, config.explain(Error(errNo))
 	}
 	return &rv, nil
 }
@@ -68,6 +83,9 @@ const (
 
 // Commit all pending changes into disk.
 func (f *File) Commit(opt CommitOpt) error {
+	if err := f.fault(FAULT_COMMIT); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_commit call f:%p dbfile:%p opt:%v", f, f.dbfile, opt)
 	errNo := C.fdb_commit(f.dbfile, C.fdb_commit_opt_t(opt))
 	Log.Tracef("fdb_commit retn f:%p errNo:%v", f, errNo)
@@ -79,6 +97,9 @@ func (f *File) Commit(opt CommitOpt) error {
 
 // Compact the current database file and create a new compacted file
 func (f *File) Compact(newfilename string) error {
+	if err := f.fault(FAULT_COMPACT); err != nil {
+		return err
+	}
 
 	fn := C.CString(newfilename)
 	defer C.free(unsafe.Pointer(fn))
@@ -95,6 +116,9 @@ func (f *File) Compact(newfilename string) error {
 // CompactUpto compacts the current database file upto given snapshot marker
 //and creates a new compacted file
 func (f *File) CompactUpto(newfilename string, sm *SnapMarker) error {
+	if err := f.fault(FAULT_COMPACT); err != nil {
+		return err
+	}
 
 	fn := C.CString(newfilename)
 	defer C.free(unsafe.Pointer(fn))
@@ -159,8 +183,9 @@ func (f *File) OpenKVStore(name string, config *KVStoreConfig) (*KVStore, error)
 	}
 
 	rv := KVStore{
-		f:    f,
-		name: name,
+		f:      f,
+		name:   name,
+		config: config,
 	}
 	kvsname := C.CString(name)
 	defer C.free(unsafe.Pointer(kvsname))
@@ -180,6 +205,20 @@ func (f *File) OpenKVStoreDefault(config *KVStoreConfig) (*KVStore, error) {
 	return f.OpenKVStore("default", config)
 }
 
+// RemoveKVStore permanently removes the named KVStore and all of its
+// data from the File.  All handles on the KVStore must be closed.
+func (f *File) RemoveKVStore(name string) error {
+	kvsname := C.CString(name)
+	defer C.free(unsafe.Pointer(kvsname))
+	Log.Tracef("fdb_kvs_remove call f:%p dbfile:%p kvsname:%v", f, f.dbfile, kvsname)
+	errNo := C.fdb_kvs_remove(f.dbfile, kvsname)
+	Log.Tracef("fdb_kvs_remove retn f:%p errNo:%v", f, errNo)
+	if errNo != RESULT_SUCCESS {
+		return Error(errNo)
+	}
+	return nil
+}
+
 func (f *File) GetKVStoreNames() ([]string, error) {
 	var ninfo C.fdb_kvs_name_list
 	errNo := C.fdb_get_kvs_name_list(f.dbfile, &ninfo)
@@ -34,13 +34,24 @@ package forestdb
 //}
 import "C"
 
-import "unsafe"
+import (
+	"sync"
+	"unsafe"
+)
 
 // KVStore handle
 type KVStore struct {
-	f    *File
-	db   *C.fdb_kvs_handle
-	name string
+	f      *File
+	db     *C.fdb_kvs_handle
+	name   string
+	config *KVStoreConfig
+	// serializes read-modify-write operations (compare-and-swap,
+	// merges) through this handle
+	writeMutex sync.Mutex
+	merge      MergeFunc
+	// the doc GetInto reads into, and the size of its key buffer
+	getDoc    *Doc
+	getKeyCap int
 }
 
 // File returns the File containing this KVStore
@@ -55,6 +66,15 @@ func (k *KVStore) Handle() *C.fdb_kvs_handle {
 
 // Close the KVStore and release related resources.
 func (k *KVStore) Close() error {
+	if k.getDoc != nil {
+		k.getDoc.Close()
+		k.getDoc = nil
+		k.getKeyCap = 0
+	}
+	if k.db == nil {
+		// left without a handle by a failed Truncate
+		return nil
+	}
 	Log.Tracef("fdb_kvs_close call k:%p db:%p", k, k.db)
 	errNo := C.fdb_kvs_close(k.db)
 	Log.Tracef("fdb_kvs_close retn k:%p errNo:%v", k, errNo)
@@ -64,8 +84,20 @@ func (k *KVStore) Close() error {
 	return nil
 }
 
+// checkHandle returns RESULT_INVALID_HANDLE if a failed Truncate left
+// the KVStore without a handle, so it is never passed to C as NULL
+func (k *KVStore) checkHandle() error {
+	if k.db == nil {
+		return RESULT_INVALID_HANDLE
+	}
+	return nil
+}
+
 // Info returns the information about a given kvstore
 func (k *KVStore) Info() (*KVStoreInfo, error) {
+	if err := k.checkHandle(); err != nil {
+		return nil, err
+	}
 	rv := KVStoreInfo{}
 	Log.Tracef("fdb_get_kvs_info call k:%p db:%p", k, k.db)
 	errNo := C.fdb_get_kvs_info(k.db, &rv.info)
@@ -78,6 +110,9 @@ func (k *KVStore) Info() (*KVStoreInfo, error) {
 
 // OpsInfo returns the information about the ops on given kvstore
 func (k *KVStore) OpsInfo() (*KVSOpsInfo, error) {
+	if err := k.checkHandle(); err != nil {
+		return nil, err
+	}
 	rv := KVSOpsInfo{}
 	Log.Tracef("fdb_get_kvs_ops_info call k:%p db:%p", k, k.db)
 	errNo := C.fdb_get_kvs_ops_info(k.db, &rv.info)
@@ -90,6 +125,12 @@ func (k *KVStore) OpsInfo() (*KVSOpsInfo, error) {
 
 // Get retrieves the metadata and doc body for a given key
 func (k *KVStore) Get(doc *Doc) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_GET); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_get call k:%p db:%p doc:%v", k, k.db, doc.doc)
 	errNo := C.fdb_get(k.db, doc.doc)
 	Log.Tracef("fdb_get retn k:%p errNo:%v doc:%v", k, errNo, doc.doc)
@@ -101,6 +142,12 @@ func (k *KVStore) Get(doc *Doc) error {
 
 // GetMetaOnly retrieves the metadata for a given key
 func (k *KVStore) GetMetaOnly(doc *Doc) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_GET); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_get_metaonly call k:%p db:%p doc:%v", k, k.db, doc.doc)
 	errNo := C.fdb_get_metaonly(k.db, doc.doc)
 	Log.Tracef("fdb_get_metaonly retn k:%p errNo:%v doc:%v", k, errNo, doc.doc)
@@ -112,6 +159,12 @@ func (k *KVStore) GetMetaOnly(doc *Doc) error {
 
 // GetBySeq retrieves the metadata and doc body for a given sequence number
 func (k *KVStore) GetBySeq(doc *Doc) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_GET); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_get_byseq call k:%p db:%p doc:%v", k, k.db, doc.doc)
 	errNo := C.fdb_get_byseq(k.db, doc.doc)
 	Log.Tracef("fdb_get_byseq retn k:%p errNo:%v doc:%v", k, errNo, doc.doc)
@@ -123,6 +176,12 @@ func (k *KVStore) GetBySeq(doc *Doc) error {
 
 // GetMetaOnlyBySeq retrieves the metadata for a given sequence number
 func (k *KVStore) GetMetaOnlyBySeq(doc *Doc) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_GET); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_get_metaonly_byseq call k:%p db:%p doc:%v", k, k.db, doc.doc)
 	errNo := C.fdb_get_metaonly_byseq(k.db, doc.doc)
 	Log.Tracef("fdb_get_metaonly_byseq retn k:%p errNo:%v doc:%v", k, errNo, doc.doc)
@@ -134,6 +193,12 @@ func (k *KVStore) GetMetaOnlyBySeq(doc *Doc) error {
 
 // GetByOffset retrieves a doc's metadata and body with a given doc offset in the database file
 func (k *KVStore) GetByOffset(doc *Doc) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_GET); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_get_byoffset call k:%p db:%p doc:%v", k, k.db, doc.doc)
 	errNo := C.fdb_get_byoffset(k.db, doc.doc)
 	Log.Tracef("fdb_get_byoffset retn k:%p errNo:%v doc:%v", k, errNo, doc.doc)
@@ -145,6 +210,12 @@ func (k *KVStore) GetByOffset(doc *Doc) error {
 
 // Set update the metadata and doc body for a given key
 func (k *KVStore) Set(doc *Doc) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_SET); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_set call k:%p db:%p doc:%v", k, k.db, doc.doc)
 	errNo := C.fdb_set(k.db, doc.doc)
 	Log.Tracef("fdb_set retn k:%p errNo:%v doc:%v", k, errNo, doc.doc)
@@ -156,6 +227,12 @@ func (k *KVStore) Set(doc *Doc) error {
 
 // Delete deletes a key, its metadata and value
 func (k *KVStore) Delete(doc *Doc) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_DELETE); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_del call k:%p db:%p doc:%v", k, k.db, doc.doc)
 	errNo := C.fdb_del(k.db, doc.doc)
 	Log.Tracef("fdb_set retn k:%p errNo:%v doc:%v", k, errNo, doc.doc)
@@ -193,6 +270,9 @@ func registerLogCallback(cb LogCallback, ctx interface{}) int {
 }
 
 func (k *KVStore) SetLogCallback(l LogCallback, userCtx interface{}) {
+	if k.checkHandle() != nil {
+		return
+	}
 	var ctx C.log_context
 	ctx.offset = C.int(registerLogCallback(l, userCtx))
 	ctx.name = C.CString(k.name)
@@ -0,0 +1,172 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/goforestdb/store"
+	"github.com/couchbase/goforestdb/store/storetest"
+)
+
+// FuzzKVStore runs random programs of sets, deletes, commits, batches,
+// transactions, snapshots, rollbacks and iterator moves against a KVStore
+// and a store.MemStore, failing on the first difference.  See
+// storetest.Differential for how programs are interpreted.
+func FuzzKVStore(f *testing.F) {
+	// set, set, commit, batch, iterate with seeks, rollback
+	f.Add([]byte{0, 1, 0, 2, 3, 4, 1, 3, 1, 0, 4, 1, 6, 1, 2, 0x0e, 5, 5, 3, 0, 1, 5, 10, 0, 8, 1})
+	// sets and deletes iterated with ITR_NO_DELETES and skipped bounds
+	f.Add([]byte{0, 0, 0, 1, 0, 2, 1, 1, 6, 0, 0, 0x0e, 4, 0, 1, 0, 2, 5, 5, 3, 4, 0, 3, 0})
+	// in-memory snapshot after a committed transaction
+	f.Add([]byte{5, 2, 0, 1, 0, 1, 3, 7, 1, 0, 0, 0, 4, 4})
+
+	f.Fuzz(func(t *testing.T, program []byte) {
+		// fuzz workers share the package directory, so each input
+		// gets a file of its own
+		kvstore, err := OpenFileKVStore(filepath.Join(t.TempDir(), "db"), nil, "default", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer CloseFileKVStore(kvstore)
+
+		storetest.Differential(t, program, store.NewMemStore(), kvstore.AsStore())
+	})
+}
+
+// FuzzIteratorSeek checks Iterator.Seek against a sorted list of keys.
+// Bit i of present and deleted selects whether key i is set, and whether
+// it is then deleted; start and end pick the bounds of the range.
+func FuzzIteratorSeek(f *testing.F) {
+	f.Add(uint16(0xffff), uint16(0), byte(0), byte(0), uint8(ITR_NONE), byte(3), false)
+	f.Add(uint16(0x5555), uint16(0x0101), byte(2), byte(12), uint8(ITR_NO_DELETES), byte(0), true)
+	f.Add(uint16(0x0f0f), uint16(0), byte(1), byte(11), uint8(FDB_ITR_SKIP_MIN_KEY|FDB_ITR_SKIP_MAX_KEY), byte(11), false)
+	f.Add(uint16(0x00f0), uint16(0x0080), byte(5), byte(7), uint8(ITR_NO_DELETES|FDB_ITR_SKIP_MAX_KEY), byte(15), true)
+
+	f.Fuzz(func(t *testing.T, present, deleted uint16, start, end byte, opt uint8, seek byte, lower bool) {
+		// fuzz workers share the package directory, so each input
+		// gets a file of its own
+		kvstore, err := OpenFileKVStore(filepath.Join(t.TempDir(), "db"), nil, "default", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer CloseFileKVStore(kvstore)
+
+		// keys "b", "d", ... leave room to seek between them
+		key := func(i byte) []byte {
+			return []byte{'b' + 2*(i%16)}
+		}
+		var all [][]byte
+		for i := byte(0); i < 16; i++ {
+			if present&(1<<i) == 0 {
+				continue
+			}
+			err = kvstore.SetKV(key(i), key(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted&(1<<i) != 0 {
+				err = kvstore.DeleteKV(key(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if IteratorOpt(opt)&ITR_NO_DELETES != 0 {
+					continue
+				}
+			}
+			all = append(all, key(i))
+		}
+
+		// a bound of 16 or more leaves the range open
+		var startKey, endKey []byte
+		if start < 16 {
+			startKey = key(start)
+		}
+		if end < 16 {
+			endKey = key(end)
+		}
+		iopt := IteratorOpt(opt) & (ITR_NO_DELETES | FDB_ITR_SKIP_MIN_KEY | FDB_ITR_SKIP_MAX_KEY)
+		var expected [][]byte
+		for _, k := range all {
+			if (startKey != nil && (bytes.Compare(k, startKey) < 0 ||
+				(iopt&FDB_ITR_SKIP_MIN_KEY != 0 && bytes.Equal(k, startKey)))) ||
+				(endKey != nil && (bytes.Compare(k, endKey) > 0 ||
+					(iopt&FDB_ITR_SKIP_MAX_KEY != 0 && bytes.Equal(k, endKey)))) {
+				continue
+			}
+			expected = append(expected, k)
+		}
+
+		iter, err := kvstore.IteratorInit(startKey, endKey, iopt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer iter.Close()
+
+		// odd seek keys fall between the stored keys
+		seekKey := []byte{'a' + seek%34}
+		dir := FDB_ITR_SEEK_HIGHER
+		pos := 0
+		for pos < len(expected) && bytes.Compare(expected[pos], seekKey) < 0 {
+			pos++
+		}
+		if lower {
+			dir = FDB_ITR_SEEK_LOWER
+			if pos == len(expected) || !bytes.Equal(expected[pos], seekKey) {
+				pos--
+			}
+		}
+
+		err = iter.Seek(seekKey, dir)
+		if pos < 0 || pos >= len(expected) {
+			if err == nil {
+				doc, gerr := iter.Get()
+				if gerr == nil {
+					t.Errorf("seek %s dir %d: expected no doc, got %s", seekKey, dir, doc.Key())
+					doc.Close()
+				} else if !isIterEnd(gerr) {
+					t.Errorf("seek %s dir %d: get: %v", seekKey, dir, gerr)
+				}
+			} else if !isIterEnd(err) {
+				t.Errorf("seek %s dir %d: %v", seekKey, dir, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("seek %s dir %d: expected %s, got %v", seekKey, dir, expected[pos], err)
+		}
+
+		// the iterator continues in order from the seek position
+		var got [][]byte
+		for {
+			doc, err := iter.GetMetaOnly()
+			if isIterEnd(err) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, doc.Key())
+			doc.Close()
+			if iter.Next() != nil {
+				break
+			}
+		}
+		if len(got) != len(expected)-pos {
+			t.Fatalf("seek %s dir %d: expected %q, got %q", seekKey, dir, expected[pos:], got)
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], expected[pos+i]) {
+				t.Fatalf("seek %s dir %d: expected %q, got %q", seekKey, dir, expected[pos:], got)
+			}
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// GroupCommitter serializes writes from many goroutines onto a single
+// File and amortizes the cost of Commit across them.  Writes are applied
+// in the order they are received and a single Commit is issued once
+// maxBatch writes are pending or interval has elapsed since the first
+// pending write, whichever comes first.  Each writer is woken only after
+// the Commit covering its write has completed.
+//
+// Once handed to a GroupCommitter, the File and the KVStores used with
+// it must not be used by other goroutines until Close returns.
+//
+// If a Commit fails, every writer of the group receives its error, and
+// the committer stops for good: later writes are not applied and fail
+// with the same error, as does Close.  The writes of the failed group
+// remain in the WAL, where any later Commit of the File would persist
+// them, so the File should be closed without committing, or its KVStores
+// rolled back to their last committed seqnum, before it is used again.
+type GroupCommitter struct {
+	f        *File
+	interval time.Duration
+	maxBatch int
+	opt      CommitOpt
+	// the Commit failure that stopped the committer, only accessed by
+	// run until done is closed
+	err error
+
+	closedMutex sync.RWMutex
+	closed      bool
+	reqs        chan *commitReq
+	done        chan struct{}
+}
+
+var GroupCommitterClosed = fmt.Errorf("group committer already closed")
+
+type commitReq struct {
+	kvs   *KVStore
+	del   bool
+	key   []byte
+	meta  []byte
+	value []byte
+	seq   SeqNum
+	err   error
+	rv    chan *commitReq
+}
+
+// NewGroupCommitter starts a GroupCommitter for the given File.
+func NewGroupCommitter(f *File, interval time.Duration, maxBatch int, opt CommitOpt) *GroupCommitter {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	rv := &GroupCommitter{
+		f:        f,
+		interval: interval,
+		maxBatch: maxBatch,
+		opt:      opt,
+		reqs:     make(chan *commitReq, maxBatch),
+		done:     make(chan struct{}),
+	}
+	go rv.run()
+	return rv
+}
+
+// Set durably stores the metadata and value for key in kvs, which must
+// belong to the committer's File, and returns the assigned sequence number.
+func (g *GroupCommitter) Set(kvs *KVStore, key, meta, value []byte) (SeqNum, error) {
+	return g.submit(&commitReq{kvs: kvs, key: key, meta: meta, value: value})
+}
+
+// Delete durably deletes key from kvs, which must belong to the
+// committer's File, and returns the assigned sequence number.
+func (g *GroupCommitter) Delete(kvs *KVStore, key []byte) (SeqNum, error) {
+	return g.submit(&commitReq{kvs: kvs, del: true, key: key})
+}
+
+func (g *GroupCommitter) submit(req *commitReq) (SeqNum, error) {
+	if req.kvs.f != g.f {
+		return 0, RESULT_INVALID_ARGS
+	}
+	req.rv = make(chan *commitReq, 1)
+
+	g.closedMutex.RLock()
+	if g.closed {
+		g.closedMutex.RUnlock()
+		return 0, GroupCommitterClosed
+	}
+	g.reqs <- req
+	g.closedMutex.RUnlock()
+
+	<-req.rv
+	return req.seq, req.err
+}
+
+// Close commits any pending writes, wakes their writers and stops the
+// committer.  It does not close the underlying File.  It returns the
+// error of the Commit that stopped the committer, if any.
+func (g *GroupCommitter) Close() error {
+	g.closedMutex.Lock()
+	if g.closed {
+		g.closedMutex.Unlock()
+		return GroupCommitterClosed
+	}
+	g.closed = true
+	close(g.reqs)
+	g.closedMutex.Unlock()
+
+	<-g.done
+	return g.err
+}
+
+func (g *GroupCommitter) run() {
+	defer close(g.done)
+
+	pending := make([]*commitReq, 0, g.maxBatch)
+	timer := time.NewTimer(g.interval)
+	timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		g.commit(pending)
+		pending = pending[:0]
+	}
+
+	for {
+		select {
+		case req, ok := <-g.reqs:
+			if !ok {
+				flush()
+				return
+			}
+			if g.err != nil {
+				req.err = g.err
+				req.rv <- req
+				continue
+			}
+			g.apply(req)
+			pending = append(pending, req)
+			if len(pending) >= g.maxBatch {
+				flush()
+			} else if len(pending) == 1 {
+				timer.Reset(g.interval)
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (g *GroupCommitter) apply(req *commitReq) {
+	doc, err := NewDoc(req.key, req.meta, req.value)
+	if err != nil {
+		req.err = err
+		return
+	}
+	defer doc.Close()
+
+	if req.del {
+		err = req.kvs.Delete(doc)
+	} else {
+		err = req.kvs.Set(doc)
+	}
+	if err != nil {
+		req.err = err
+		return
+	}
+	req.seq = doc.SeqNum()
+}
+
+func (g *GroupCommitter) commit(pending []*commitReq) {
+	if len(pending) == 0 {
+		return
+	}
+
+	var err error
+	for _, req := range pending {
+		if req.err == nil {
+			err = g.f.Commit(g.opt)
+			break
+		}
+	}
+
+	if err != nil {
+		g.err = err
+	}
+
+	for _, req := range pending {
+		if req.err == nil && err != nil {
+			req.err = err
+		}
+		req.rv <- req
+	}
+}
@@ -0,0 +1,142 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupCommitter(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	gc := NewGroupCommitter(dbfile, 10*time.Millisecond, 16, COMMIT_NORMAL)
+
+	var wg sync.WaitGroup
+	seqs := make([]SeqNum, 100)
+	errs := make([]error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("key%03d", i))
+			seqs[i], errs[i] = gc.Set(kvstore, key, nil, key)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[SeqNum]bool)
+	for i := 0; i < 100; i++ {
+		if errs[i] != nil {
+			t.Fatalf("writer %d: %v", i, errs[i])
+		}
+		if seen[seqs[i]] {
+			t.Errorf("duplicate seqnum %d", seqs[i])
+		}
+		seen[seqs[i]] = true
+	}
+
+	seq, err := gc.Delete(kvstore, []byte("key000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 101 {
+		t.Errorf("expected seqnum 101, got %d", seq)
+	}
+
+	err = gc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gc.Set(kvstore, []byte("late"), nil, nil)
+	if err != GroupCommitterClosed {
+		t.Errorf("expected %v, got %v", GroupCommitterClosed, err)
+	}
+
+	// everything acknowledged must be visible after Close
+	info, err := kvstore.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.LastSeqNum() != 101 {
+		t.Errorf("expected last seqnum 101, got %d", info.LastSeqNum())
+	}
+	_, err = kvstore.GetKV([]byte("key000"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+	val, err := kvstore.GetKV([]byte("key099"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "key099" {
+		t.Errorf("expected key099, got %s", val)
+	}
+}
+
+func TestGroupCommitterCommitFail(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	dbfile.SetFaultFunc(func(op FaultOp) error {
+		if op == FAULT_COMMIT {
+			return RESULT_COMMIT_FAIL
+		}
+		return nil
+	})
+
+	gc := NewGroupCommitter(dbfile, time.Millisecond, 1, COMMIT_NORMAL)
+	_, err = gc.Set(kvstore, []byte("a"), nil, []byte("a"))
+	if err != RESULT_COMMIT_FAIL {
+		t.Fatalf("expected %v, got %v", RESULT_COMMIT_FAIL, err)
+	}
+
+	// the committer has stopped, so later writes are not even applied
+	dbfile.SetFaultFunc(nil)
+	_, err = gc.Set(kvstore, []byte("b"), nil, []byte("b"))
+	if err != RESULT_COMMIT_FAIL {
+		t.Errorf("expected %v, got %v", RESULT_COMMIT_FAIL, err)
+	}
+	_, err = kvstore.GetKV([]byte("b"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+
+	err = gc.Close()
+	if err != RESULT_COMMIT_FAIL {
+		t.Errorf("expected %v, got %v", RESULT_COMMIT_FAIL, err)
+	}
+}
@@ -0,0 +1,340 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IndexFunc returns the index terms of a doc; a doc may have any number
+// of terms, duplicates are ignored
+type IndexFunc func(key, value []byte) [][]byte
+
+// IndexEntry is a term of an index and the key of a doc it was derived from
+type IndexEntry struct {
+	Term []byte
+	Key  []byte
+}
+
+var UnknownIndex = fmt.Errorf("unknown index")
+var CorruptIndexEntry = fmt.Errorf("corrupt index entry")
+
+// the number of docs indexed per transaction by RebuildIndex
+const indexRebuildChunkSize = 1000
+
+type storeIndex struct {
+	fn  IndexFunc
+	kvs *KVStore
+}
+
+// IndexedStore wraps a KVStore and keeps secondary indexes over its
+// docs.  Each index lives in its own KVStore of the same File, so Set and
+// Delete update the doc and all of its index entries in one transaction.
+// Writes made directly to the primary KVStore are not indexed.  Like
+// KVStore, an IndexedStore must only be used by one goroutine at a time.
+type IndexedStore struct {
+	primary *KVStore
+	indexes map[string]*storeIndex
+}
+
+// NewIndexedStore creates an IndexedStore over primary, which must have
+// been opened from a File
+func NewIndexedStore(primary *KVStore) (*IndexedStore, error) {
+	if primary.f == nil {
+		return nil, RESULT_INVALID_ARGS
+	}
+	return &IndexedStore{
+		primary: primary,
+		indexes: make(map[string]*storeIndex),
+	}, nil
+}
+
+// Primary returns the KVStore holding the docs
+func (s *IndexedStore) Primary() *KVStore {
+	return s.primary
+}
+
+// AddIndex declares an index, opening the KVStore holding its entries.
+// Index entries persist, so an index must be added with the same
+// function each time the store is opened.  Docs written before the
+// index existed are only indexed by RebuildIndex.  Names containing
+// ':' are reserved for the package's own companion KVStores.
+func (s *IndexedStore) AddIndex(name string, fn IndexFunc, config *KVStoreConfig) error {
+	if strings.ContainsRune(name, ':') {
+		return RESULT_INVALID_ARGS
+	}
+	if _, ok := s.indexes[name]; ok {
+		return RESULT_INVALID_ARGS
+	}
+	kvs, err := s.primary.f.OpenKVStore(s.primary.name+"#"+name, config)
+	if err != nil {
+		return err
+	}
+	s.indexes[name] = &storeIndex{fn: fn, kvs: kvs}
+	return nil
+}
+
+func (s *IndexedStore) index(name string) (*storeIndex, error) {
+	idx, ok := s.indexes[name]
+	if !ok {
+		return nil, UnknownIndex
+	}
+	return idx, nil
+}
+
+// indexEntryKey encodes term and key so that entries sort by term, then
+// by key.  0x00 bytes in the term are escaped as 0x00 0xFF and the term
+// is terminated by 0x00 0x01.
+func indexEntryKey(term, key []byte) []byte {
+	rv := make([]byte, 0, len(term)+len(key)+2)
+	rv = appendIndexTerm(rv, term)
+	return append(rv, key...)
+}
+
+func appendIndexTerm(dst, term []byte) []byte {
+	for _, c := range term {
+		dst = append(dst, c)
+		if c == 0x00 {
+			dst = append(dst, 0xFF)
+		}
+	}
+	return append(dst, 0x00, 0x01)
+}
+
+// splitIndexEntry decodes a key encoded by indexEntryKey
+func splitIndexEntry(entry []byte) (IndexEntry, error) {
+	term := make([]byte, 0, len(entry))
+	for i := 0; i < len(entry); i++ {
+		if entry[i] != 0x00 {
+			term = append(term, entry[i])
+			continue
+		}
+		if i+1 >= len(entry) {
+			break
+		}
+		switch entry[i+1] {
+		case 0xFF:
+			term = append(term, 0x00)
+			i++
+		case 0x01:
+			return IndexEntry{Term: term, Key: entry[i+2:]}, nil
+		default:
+			return IndexEntry{}, CorruptIndexEntry
+		}
+	}
+	return IndexEntry{}, CorruptIndexEntry
+}
+
+// indexTerms returns the distinct terms of a doc, or none if it does not exist
+func indexTerms(fn IndexFunc, key, value []byte, exists bool) map[string]struct{} {
+	rv := make(map[string]struct{})
+	if !exists {
+		return rv
+	}
+	for _, term := range fn(key, value) {
+		rv[string(term)] = struct{}{}
+	}
+	return rv
+}
+
+// write applies the new value of key, or its deletion, and the
+// resulting index changes in one transaction
+func (s *IndexedStore) write(key, value []byte, del bool, opt CommitOpt) error {
+	old, err := s.primary.GetKV(key)
+	exists := err == nil
+	if err != nil && err != RESULT_KEY_NOT_FOUND {
+		return err
+	}
+
+	batch := NewKVBatch()
+	defer batch.Reset()
+	for _, idx := range s.indexes {
+		oldTerms := indexTerms(idx.fn, key, old, exists)
+		newTerms := indexTerms(idx.fn, key, value, !del)
+		for term := range oldTerms {
+			if _, ok := newTerms[term]; !ok {
+				batch.DeleteIn(idx.kvs, indexEntryKey([]byte(term), key))
+			}
+		}
+		for term := range newTerms {
+			if _, ok := oldTerms[term]; !ok {
+				batch.SetIn(idx.kvs, indexEntryKey([]byte(term), key), nil, nil)
+			}
+		}
+	}
+	if del {
+		if !exists {
+			return nil
+		}
+		batch.Delete(key)
+	} else {
+		batch.Set(key, value)
+	}
+	return s.primary.ExecuteBatch(batch, opt)
+}
+
+// Set stores the value for key and updates all indexes atomically
+func (s *IndexedStore) Set(key, value []byte, opt CommitOpt) error {
+	return s.write(key, value, false, opt)
+}
+
+// Delete deletes key and its index entries atomically
+func (s *IndexedStore) Delete(key []byte, opt CommitOpt) error {
+	return s.write(key, nil, true, opt)
+}
+
+// Get returns the value for key
+func (s *IndexedStore) Get(key []byte) ([]byte, error) {
+	return s.primary.GetKV(key)
+}
+
+// Lookup returns the keys of the docs having term in the named index,
+// in key order
+func (s *IndexedStore) Lookup(index string, term []byte) ([][]byte, error) {
+	prefix := appendIndexTerm(nil, term)
+	startKey, endKey, opt := PrefixRange(prefix)
+	entries, err := s.scanIndex(index, startKey, endKey, opt)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([][]byte, len(entries))
+	for i, entry := range entries {
+		rv[i] = entry.Key
+	}
+	return rv, nil
+}
+
+// IndexRange returns the entries of the named index with terms between
+// startTerm and endTerm (inclusive), ordered by term and then key.  A nil
+// bound leaves that end of the range open.
+func (s *IndexedStore) IndexRange(index string, startTerm, endTerm []byte) ([]IndexEntry, error) {
+	var startKey, endKey []byte
+	var opt IteratorOpt
+	if startTerm != nil {
+		startKey = appendIndexTerm(nil, startTerm)
+	}
+	if endTerm != nil {
+		endKey = PrefixSuccessor(appendIndexTerm(nil, endTerm))
+		opt = FDB_ITR_SKIP_MAX_KEY
+	}
+	return s.scanIndex(index, startKey, endKey, opt)
+}
+
+func (s *IndexedStore) scanIndex(index string, startKey, endKey []byte, opt IteratorOpt) ([]IndexEntry, error) {
+	idx, err := s.index(index)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := idx.kvs.IteratorInit(startKey, endKey, opt|ITR_NO_DELETES)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var rv []IndexEntry
+	for {
+		doc, err := iter.GetMetaOnly()
+		if isIterEnd(err) {
+			return rv, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entry, err := splitIndexEntry(doc.Key())
+		doc.Close()
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, entry)
+		if iter.Next() != nil {
+			return rv, nil
+		}
+	}
+}
+
+// RebuildIndex discards the entries of the named index and recreates
+// them from all docs of the primary KVStore, one transaction per chunk
+// of docs.  The index is incomplete until RebuildIndex returns.
+func (s *IndexedStore) RebuildIndex(index string) error {
+	idx, err := s.index(index)
+	if err != nil {
+		return err
+	}
+	err = idx.kvs.Truncate()
+	if err != nil {
+		return err
+	}
+
+	var startKey []byte
+	opt := ITR_NO_DELETES
+	batch := NewKVBatch()
+	defer batch.Reset()
+	for {
+		batch.Reset()
+		last, docs, err := s.collectIndexEntries(idx, batch, startKey, opt)
+		if err != nil {
+			return err
+		}
+		err = idx.kvs.ExecuteBatch(batch, COMMIT_NORMAL)
+		if err != nil {
+			return err
+		}
+		if docs < indexRebuildChunkSize {
+			return nil
+		}
+		// resume after the last doc indexed
+		startKey = last
+		opt |= FDB_ITR_SKIP_MIN_KEY
+	}
+}
+
+// collectIndexEntries queues the entries of up to indexRebuildChunkSize
+// docs and returns the last key read and the number of docs read.  As
+// with DeleteRange, the iterator is closed before the batch is executed.
+func (s *IndexedStore) collectIndexEntries(idx *storeIndex, b *KVBatch, startKey []byte, opt IteratorOpt) ([]byte, int, error) {
+	iter, err := s.primary.IteratorInit(startKey, nil, opt)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer iter.Close()
+
+	var last []byte
+	docs := 0
+	for docs < indexRebuildChunkSize {
+		doc, err := iter.Get()
+		if isIterEnd(err) {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		last = doc.Key()
+		for term := range indexTerms(idx.fn, last, doc.Body(), true) {
+			b.Set(indexEntryKey([]byte(term), last), nil)
+		}
+		doc.Close()
+		docs++
+		if iter.Next() != nil {
+			break
+		}
+	}
+	return last, docs, nil
+}
+
+// Close closes the index KVStores; the primary KVStore is left open
+func (s *IndexedStore) Close() (rverr error) {
+	for name, idx := range s.indexes {
+		err := idx.kvs.Close()
+		if err != nil && rverr == nil {
+			rverr = err
+		}
+		delete(s.indexes, name)
+	}
+	return
+}
@@ -0,0 +1,173 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// indexes the comma separated words of a value
+func wordsIndex(key, value []byte) [][]byte {
+	return bytes.Split(value, []byte(","))
+}
+
+func TestIndexedStore(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	store, err := NewIndexedStore(kvstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	err = store.AddIndex("words", wordsIndex, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ':' is reserved for companion KVStores such as the bloom filter's
+	err = store.AddIndex("x:bloom", wordsIndex, nil)
+	if err != RESULT_INVALID_ARGS {
+		t.Errorf("expected %v, got %v", RESULT_INVALID_ARGS, err)
+	}
+
+	for key, value := range map[string]string{
+		"doc1": "red,green",
+		"doc2": "green,blue",
+		"doc3": "blue",
+	} {
+		err = store.Set([]byte(key), []byte(value), COMMIT_NORMAL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := store.Lookup("words", []byte("green"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{[]byte("doc1"), []byte("doc2")}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %q, got %q", expected, keys)
+	}
+
+	// updates and deletes remove stale entries
+	err = store.Set([]byte("doc1"), []byte("red"), COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Delete([]byte("doc3"), COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.IndexRange("words", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEntries := []IndexEntry{
+		{Term: []byte("blue"), Key: []byte("doc2")},
+		{Term: []byte("green"), Key: []byte("doc2")},
+		{Term: []byte("red"), Key: []byte("doc1")},
+	}
+	if !reflect.DeepEqual(entries, expectedEntries) {
+		t.Errorf("expected %q, got %q", expectedEntries, entries)
+	}
+
+	entries, err = store.IndexRange("words", []byte("c"), []byte("green"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, expectedEntries[1:2]) {
+		t.Errorf("expected %q, got %q", expectedEntries[1:2], entries)
+	}
+
+	_, err = store.Lookup("nosuch", []byte("red"))
+	if err != UnknownIndex {
+		t.Errorf("expected UnknownIndex, got %v", err)
+	}
+}
+
+func TestIndexedStoreRebuild(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// written before the index exists
+	err = kvstore.SetKV([]byte("doc1"), []byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.SetKV([]byte("doc2"), []byte("red,blue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewIndexedStore(kvstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	err = store.AddIndex("words", wordsIndex, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := store.Lookup("words", []byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("expected no keys before rebuild, got %q", keys)
+	}
+
+	err = store.RebuildIndex("words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err = store.Lookup("words", []byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{[]byte("doc1"), []byte("doc2")}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %q, got %q", expected, keys)
+	}
+}
+
+func TestIndexEntryKey(t *testing.T) {
+	terms := [][]byte{{}, {0x00}, {0x00, 0x00}, {0x00, 0x01}, []byte("a"), []byte("a\x00b"), []byte("ab")}
+	var prev []byte
+	for _, term := range terms {
+		entry := indexEntryKey(term, []byte("key\x00"))
+		if prev != nil && bytes.Compare(prev, entry) >= 0 {
+			t.Errorf("entry for %q does not sort after the previous term", term)
+		}
+		prev = entry
+
+		decoded, err := splitIndexEntry(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.Term, term) || string(decoded.Key) != "key\x00" {
+			t.Errorf("expected %q/key, got %q/%q", term, decoded.Term, decoded.Key)
+		}
+	}
+}
@@ -43,6 +43,7 @@ const (
 // Iterator handle
 type Iterator struct {
 	iter *C.fdb_iterator
+	view *Doc
 }
 
 // Prev advances the iterator backwards
@@ -91,6 +92,42 @@ func (i *Iterator) GetPreAlloc(rv *Doc) error {
 	return nil
 }
 
+// GetReuse gets the current item (key, metadata, doc body) from the iterator
+// into an existing Doc, typically one obtained from a DocPool.  Unlike
+// GetPreAlloc the buffers previously held by the Doc are released first,
+// so the caller does not need to size them in advance.
+func (i *Iterator) GetReuse(rv *Doc) error {
+	rv.reset()
+	Log.Tracef("fdb_iterator_get call i:%p iter:%p", i, i.iter)
+	errNo := C.fdb_iterator_get(i.iter, &rv.doc)
+	Log.Tracef("fdb_iterator_get retn i:%p errNo:%v iter:%p doc:%v", i, errNo, i.iter, rv.doc)
+	if errNo != RESULT_SUCCESS {
+		return Error(errNo)
+	}
+	return nil
+}
+
+// GetView gets the current item (key, metadata, doc body) from the iterator
+// into a Doc owned by the iterator and in view mode.  The same Doc is
+// returned on every call, so the slices returned by its Key, Meta and Body
+// are only valid until the iterator is moved or closed.  The caller must
+// not Close the returned Doc.
+func (i *Iterator) GetView() (*Doc, error) {
+	if i.view == nil {
+		doc, err := NewDoc(nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		doc.SetView(true)
+		i.view = doc
+	}
+	err := i.GetReuse(i.view)
+	if err != nil {
+		return nil, err
+	}
+	return i.view, nil
+}
+
 // GetMetaOnly gets the current item (key, metadata, offset to doc body) from the iterator
 func (i *Iterator) GetMetaOnly() (*Doc, error) {
 	rv := Doc{}
@@ -149,6 +186,10 @@ func (i *Iterator) SeekMax() error {
 
 // Close the iterator and free its associated resources
 func (i *Iterator) Close() error {
+	if i.view != nil {
+		i.view.Close()
+		i.view = nil
+	}
 	Log.Tracef("fdb_iterator_close call i:%p iter:%p", i, i.iter)
 	errNo := C.fdb_iterator_close(i.iter)
 	Log.Tracef("fdb_iterator_close retn i:%p errNo:%v iter:%p", i, errNo, i.iter)
@@ -160,6 +201,12 @@ func (i *Iterator) Close() error {
 
 // IteratorInit creates an iterator to traverse a ForestDB snapshot by key range
 func (k *KVStore) IteratorInit(startKey, endKey []byte, opt IteratorOpt) (*Iterator, error) {
+	if err := k.checkHandle(); err != nil {
+		return nil, err
+	}
+	if err := k.fault(FAULT_ITERATOR_INIT); err != nil {
+		return nil, err
+	}
 	var sk, ek unsafe.Pointer
 
 	lensk := len(startKey)
@@ -185,6 +232,12 @@ func (k *KVStore) IteratorInit(startKey, endKey []byte, opt IteratorOpt) (*Itera
 
 // IteratorSequenceInit create an iterator to traverse a ForestDB snapshot by sequence number range
 func (k *KVStore) IteratorSequenceInit(startSeq, endSeq SeqNum, opt IteratorOpt) (*Iterator, error) {
+	if err := k.checkHandle(); err != nil {
+		return nil, err
+	}
+	if err := k.fault(FAULT_ITERATOR_INIT); err != nil {
+		return nil, err
+	}
 	rv := Iterator{}
 	Log.Tracef("fdb_iterator_sequence_init call k:%p db:%p sseq:%v eseq:%v opt:%v", k, k.db, startSeq, endSeq, opt)
 	errNo := C.fdb_iterator_sequence_init(k.db, &rv.iter, C.fdb_seqnum_t(startSeq), C.fdb_seqnum_t(endSeq), C.fdb_iterator_opt_t(opt))
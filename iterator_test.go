@@ -490,3 +490,57 @@ func TestForestDBIteratorBug(t *testing.T) {
 	iter.Close()
 
 }
+
+func TestForestDBIteratorGetView(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	kvstore.SetKV([]byte("a"), []byte("vala"))
+	kvstore.SetKV([]byte("b"), []byte("valb"))
+	kvstore.SetKV([]byte("c"), []byte("valc"))
+
+	iter, err := kvstore.IteratorInit(nil, nil, ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	var keys, vals []string
+	var prev *Doc
+	for {
+		doc, err := iter.GetView()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !doc.View() {
+			t.Errorf("expected doc in view mode")
+		}
+		if prev != nil && prev != doc {
+			t.Errorf("expected the same doc to be reused")
+		}
+		prev = doc
+		// view slices must be copied to outlive the iterator position
+		keys = append(keys, string(doc.Key()))
+		vals = append(vals, string(doc.Body()))
+		if iter.Next() != nil {
+			break
+		}
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Errorf("expected keys [a b c], got %v", keys)
+	}
+	if !reflect.DeepEqual(vals, []string{"vala", "valb", "valc"}) {
+		t.Errorf("expected values [vala valb valc], got %v", vals)
+	}
+}
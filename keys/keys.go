@@ -0,0 +1,348 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// Package keys encodes tuples of values as byte strings whose bytewise
+// order matches the logical order of the tuples, so composite keys sort
+// correctly under ForestDB's default comparator without a custom one.
+//
+// Tuples are compared element by element, and a tuple sorts before any
+// longer tuple it is a prefix of.  Elements of different types are ordered
+// by type: nil, byte slices, strings, nested tuples, integers, float32,
+// float64, booleans and finally descending wrappers, which reverse the
+// order of the wrapped elements, types included.  Signed and unsigned
+// integers share a single ordering by value.
+package keys
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Tuple is a sequence of elements, each of which is nil, []byte,
+// string, a signed or unsigned integer, float32, float64, bool, a
+// nested Tuple or Desc.
+type Tuple []interface{}
+
+// Desc wraps an element so that it sorts in descending order
+type Desc struct {
+	Value interface{}
+}
+
+var InvalidEncoding = fmt.Errorf("keys: invalid tuple encoding")
+
+const (
+	codeNil     = 0x00
+	codeBytes   = 0x01
+	codeString  = 0x02
+	codeNested  = 0x05
+	codeIntZero = 0x14
+	codeFloat32 = 0x20
+	codeFloat64 = 0x21
+	codeFalse   = 0x26
+	codeTrue    = 0x27
+	codeDesc    = 0x40
+
+	// never starts an element, so it bounds all tuples with a given prefix
+	codeMax = 0xFF
+	// follows an escaped 0x00 byte or a nil within a nested tuple
+	escape = 0xFF
+)
+
+// Pack encodes the tuple
+func Pack(t Tuple) ([]byte, error) {
+	var rv []byte
+	var err error
+	for _, e := range t {
+		rv, err = appendElement(rv, e, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// Range returns the bounds of all tuples starting with the elements of
+// prefix, including prefix itself.  Both bounds are inclusive, so they can
+// be passed straight to IteratorInit.
+func Range(prefix Tuple) (start, end []byte, err error) {
+	start, err = Pack(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	end = make([]byte, len(start)+1)
+	copy(end, start)
+	end[len(start)] = codeMax
+	return start, end, nil
+}
+
+// Unpack decodes a tuple encoded by Pack.  Integers are returned as int64,
+// or as uint64 if they are larger than math.MaxInt64.
+func Unpack(b []byte) (Tuple, error) {
+	rv := Tuple{}
+	for len(b) > 0 {
+		e, n, err := decodeElement(b, false)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, e)
+		b = b[n:]
+	}
+	return rv, nil
+}
+
+func appendElement(dst []byte, e interface{}, nested bool) ([]byte, error) {
+	switch v := e.(type) {
+	case nil:
+		if nested {
+			return append(dst, codeNil, escape), nil
+		}
+		return append(dst, codeNil), nil
+	case []byte:
+		return appendEscaped(append(dst, codeBytes), v), nil
+	case string:
+		return appendEscaped(append(dst, codeString), []byte(v)), nil
+	case Tuple:
+		dst = append(dst, codeNested)
+		var err error
+		for _, ne := range v {
+			dst, err = appendElement(dst, ne, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, codeNil), nil
+	case int:
+		return appendInt(dst, int64(v)), nil
+	case int8:
+		return appendInt(dst, int64(v)), nil
+	case int16:
+		return appendInt(dst, int64(v)), nil
+	case int32:
+		return appendInt(dst, int64(v)), nil
+	case int64:
+		return appendInt(dst, v), nil
+	case uint:
+		return appendUint(dst, uint64(v)), nil
+	case uint8:
+		return appendUint(dst, uint64(v)), nil
+	case uint16:
+		return appendUint(dst, uint64(v)), nil
+	case uint32:
+		return appendUint(dst, uint64(v)), nil
+	case uint64:
+		return appendUint(dst, v), nil
+	case float32:
+		bits := uint64(math.Float32bits(v))
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(orderFloatBits(bits, 32)))
+		return append(append(dst, codeFloat32), buf[:]...), nil
+	case float64:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], orderFloatBits(math.Float64bits(v), 64))
+		return append(append(dst, codeFloat64), buf[:]...), nil
+	case bool:
+		if v {
+			return append(dst, codeTrue), nil
+		}
+		return append(dst, codeFalse), nil
+	case Desc:
+		// the inverted encoding is followed by 0xFF, which sorts above
+		// the inverted continuation of any element it is a prefix of
+		enc, err := appendElement(nil, v.Value, false)
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, codeDesc)
+		for _, c := range enc {
+			dst = append(dst, ^c)
+		}
+		return append(dst, codeMax), nil
+	}
+	return nil, fmt.Errorf("keys: unsupported type %T", e)
+}
+
+func appendEscaped(dst, b []byte) []byte {
+	for _, c := range b {
+		dst = append(dst, c)
+		if c == 0x00 {
+			dst = append(dst, escape)
+		}
+	}
+	return append(dst, 0x00)
+}
+
+// intLen returns the number of bytes needed to hold u
+func intLen(u uint64) int {
+	n := 0
+	for ; u > 0; u >>= 8 {
+		n++
+	}
+	return n
+}
+
+func appendBigEndian(dst []byte, u uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(u>>(8*uint(i))))
+	}
+	return dst
+}
+
+func appendUint(dst []byte, u uint64) []byte {
+	n := intLen(u)
+	return appendBigEndian(append(dst, byte(codeIntZero+n)), u, n)
+}
+
+// appendInt encodes negative integers by their magnitude's length and
+// its ones' complement, so that larger magnitudes sort first
+func appendInt(dst []byte, i int64) []byte {
+	if i >= 0 {
+		return appendUint(dst, uint64(i))
+	}
+	abs := uint64(-i)
+	n := intLen(abs)
+	return appendBigEndian(append(dst, byte(codeIntZero-n)), ^abs, n)
+}
+
+// orderFloatBits maps IEEE 754 bits so that they sort as unsigned
+// integers: the sign bit is flipped for positive numbers, and all bits
+// for negative ones
+func orderFloatBits(bits uint64, size uint) uint64 {
+	sign := uint64(1) << (size - 1)
+	if bits&sign != 0 {
+		return ^bits & (sign | (sign - 1))
+	}
+	return bits | sign
+}
+
+func unorderFloatBits(bits uint64, size uint) uint64 {
+	sign := uint64(1) << (size - 1)
+	if bits&sign != 0 {
+		return bits &^ sign
+	}
+	return ^bits & (sign | (sign - 1))
+}
+
+// decodeElement decodes the element at the start of b, returning it and
+// the number of bytes it occupies
+func decodeElement(b []byte, nested bool) (interface{}, int, error) {
+	code := b[0]
+	switch {
+	case code == codeNil:
+		if nested {
+			return nil, 2, nil
+		}
+		return nil, 1, nil
+	case code == codeBytes || code == codeString:
+		v, n, err := decodeEscaped(b[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		if code == codeString {
+			return string(v), n + 1, nil
+		}
+		return v, n + 1, nil
+	case code == codeNested:
+		rv := Tuple{}
+		i := 1
+		for {
+			if i >= len(b) {
+				return nil, 0, InvalidEncoding
+			}
+			if b[i] == codeNil && (i+1 >= len(b) || b[i+1] != escape) {
+				return rv, i + 1, nil
+			}
+			e, n, err := decodeElement(b[i:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			rv = append(rv, e)
+			i += n
+		}
+	case code >= codeIntZero-8 && code <= codeIntZero+8:
+		return decodeInt(b)
+	case code == codeFloat32:
+		if len(b) < 5 {
+			return nil, 0, InvalidEncoding
+		}
+		bits := unorderFloatBits(uint64(binary.BigEndian.Uint32(b[1:])), 32)
+		return math.Float32frombits(uint32(bits)), 5, nil
+	case code == codeFloat64:
+		if len(b) < 9 {
+			return nil, 0, InvalidEncoding
+		}
+		bits := unorderFloatBits(binary.BigEndian.Uint64(b[1:]), 64)
+		return math.Float64frombits(bits), 9, nil
+	case code == codeFalse:
+		return false, 1, nil
+	case code == codeTrue:
+		return true, 1, nil
+	case code == codeDesc:
+		inverted := make([]byte, len(b)-1)
+		for i, c := range b[1:] {
+			inverted[i] = ^c
+		}
+		if len(inverted) == 0 {
+			return nil, 0, InvalidEncoding
+		}
+		e, n, err := decodeElement(inverted, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		if n+1 >= len(b) || b[n+1] != codeMax {
+			return nil, 0, InvalidEncoding
+		}
+		return Desc{Value: e}, n + 2, nil
+	}
+	return nil, 0, InvalidEncoding
+}
+
+func decodeEscaped(b []byte) ([]byte, int, error) {
+	rv := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			rv = append(rv, b[i])
+		} else if i+1 < len(b) && b[i+1] == escape {
+			rv = append(rv, 0x00)
+			i++
+		} else {
+			return rv, i + 1, nil
+		}
+	}
+	return nil, 0, InvalidEncoding
+}
+
+func decodeInt(b []byte) (interface{}, int, error) {
+	n := int(b[0]) - codeIntZero
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	if len(b) < n+1 {
+		return nil, 0, InvalidEncoding
+	}
+	var u uint64
+	for _, c := range b[1 : n+1] {
+		u = u<<8 | uint64(c)
+	}
+	if !neg {
+		if u > math.MaxInt64 {
+			return u, n + 1, nil
+		}
+		return int64(u), n + 1, nil
+	}
+	abs := ^u
+	if n < 8 {
+		abs &= 1<<(8*uint(n)) - 1
+	}
+	if abs > 1<<63 {
+		return nil, 0, InvalidEncoding
+	}
+	return -int64(abs), n + 1, nil
+}
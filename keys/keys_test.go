@@ -0,0 +1,188 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// tuples in strictly increasing logical order
+var ordered = []Tuple{
+	{},
+	{nil},
+	{nil, nil},
+	{[]byte{}},
+	{[]byte{0x00}},
+	{[]byte{0x00, 0x00}},
+	{[]byte{0x00, 0x01}},
+	{[]byte("a")},
+	{[]byte("a"), nil},
+	{[]byte("a\x00b")},
+	{[]byte("b")},
+	{""},
+	{"a"},
+	{"a", int64(1)},
+	{"b"},
+	{Tuple{}},
+	{Tuple{nil}},
+	{Tuple{nil}, nil},
+	{Tuple{nil, nil}},
+	{Tuple{"a"}},
+	{Tuple{"a", nil}},
+	{int64(math.MinInt64)},
+	{int64(-1 << 32)},
+	{int64(-256)},
+	{int64(-255)},
+	{int64(-1)},
+	{int64(0)},
+	{int64(1)},
+	{int64(255)},
+	{int64(256)},
+	{int64(math.MaxInt64)},
+	{uint64(math.MaxUint64)},
+	{float32(math.Inf(-1))},
+	{float32(-1.5)},
+	{float32(0)},
+	{float32(1.5)},
+	{float32(math.Inf(1))},
+	{float64(math.Inf(-1))},
+	{float64(-1e100)},
+	{float64(-1)},
+	{float64(0)},
+	{float64(1)},
+	{float64(math.Inf(1))},
+	{false},
+	{true},
+	{Desc{true}},
+	{Desc{int64(5)}},
+	{Desc{int64(-5)}},
+	{Desc{Tuple{nil}}},
+	{Desc{Tuple{}}},
+	{Desc{"b"}},
+	{Desc{"a\x00b"}},
+	{Desc{"a"}},
+	{Desc{"a"}, "z"},
+	{Desc{""}},
+	{Desc{nil}},
+}
+
+func TestOrder(t *testing.T) {
+	var prev []byte
+	for i, tuple := range ordered {
+		packed, err := Pack(tuple)
+		if err != nil {
+			t.Fatalf("%v: %v", tuple, err)
+		}
+		if i > 0 && bytes.Compare(prev, packed) >= 0 {
+			t.Errorf("%v does not sort after %v", tuple, ordered[i-1])
+		}
+		prev = packed
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tuple := range ordered {
+		packed, err := Pack(tuple)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unpacked, err := Unpack(packed)
+		if err != nil {
+			t.Fatalf("%v: %v", tuple, err)
+		}
+		if !reflect.DeepEqual(unpacked, tuple) {
+			t.Errorf("expected %#v, got %#v", tuple, unpacked)
+		}
+	}
+}
+
+func TestIntegerTypes(t *testing.T) {
+	tests := []struct {
+		in  interface{}
+		out interface{}
+	}{
+		{int(-7), int64(-7)},
+		{int8(-7), int64(-7)},
+		{int16(300), int64(300)},
+		{int32(-70000), int64(-70000)},
+		{uint(7), int64(7)},
+		{uint8(7), int64(7)},
+		{uint16(7), int64(7)},
+		{uint32(7), int64(7)},
+		{uint64(7), int64(7)},
+	}
+	for _, test := range tests {
+		packed, err := Pack(Tuple{test.in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unpacked, err := Unpack(packed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(unpacked, Tuple{test.out}) {
+			t.Errorf("%T(%v): expected %#v, got %#v", test.in, test.in, test.out, unpacked[0])
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	start, end, err := Range(Tuple{"user", int64(7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside := []Tuple{
+		{"user", int64(7)},
+		{"user", int64(7), nil},
+		{"user", int64(7), "zzz"},
+		{"user", int64(7), Desc{nil}},
+	}
+	outside := []Tuple{
+		{"user"},
+		{"user", int64(6), "zzz"},
+		{"user", int64(8)},
+		{"user\x00", int64(7)},
+	}
+	for _, tuple := range inside {
+		packed, _ := Pack(tuple)
+		if bytes.Compare(packed, start) < 0 || bytes.Compare(packed, end) > 0 {
+			t.Errorf("expected %v inside the range", tuple)
+		}
+	}
+	for _, tuple := range outside {
+		packed, _ := Pack(tuple)
+		if bytes.Compare(packed, start) >= 0 && bytes.Compare(packed, end) <= 0 {
+			t.Errorf("expected %v outside the range", tuple)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, err := Pack(Tuple{struct{}{}})
+	if err == nil {
+		t.Errorf("expected error packing unsupported type")
+	}
+	for _, b := range [][]byte{
+		{codeBytes, 'a'},
+		{codeNested, codeNil, escape},
+		{codeIntZero + 2, 0x01},
+		{codeFloat64, 0x01},
+		{codeDesc, ^byte(codeTrue)},
+		{0x99},
+	} {
+		_, err = Unpack(b)
+		if err != InvalidEncoding {
+			t.Errorf("%x: expected InvalidEncoding, got %v", b, err)
+		}
+	}
+}
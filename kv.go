@@ -19,6 +19,12 @@ import (
 
 // GetKV simplified API for key/value access to Get()
 func (k *KVStore) GetKV(key []byte) ([]byte, error) {
+	if err := k.checkHandle(); err != nil {
+		return nil, err
+	}
+	if err := k.fault(FAULT_GET); err != nil {
+		return nil, err
+	}
 
 	var kk unsafe.Pointer
 	if len(key) != 0 {
@@ -41,8 +47,69 @@ func (k *KVStore) GetKV(key []byte) ([]byte, error) {
 	return body, nil
 }
 
+// GetInto is like GetKV but copies the value into dst, growing it only
+// if its capacity is too small, and returns the resulting slice.
+// Reusing dst across calls avoids a Go allocation per lookup.  The value
+// is read into an fdb_doc kept by the KVStore and reused, as with
+// Iterator.GetReuse, so only the buffer forestdb reads the body into is
+// allocated per lookup, and it is released by the next one.
+func (k *KVStore) GetInto(key, dst []byte) ([]byte, error) {
+	if err := k.checkHandle(); err != nil {
+		return dst[:0], err
+	}
+	if err := k.fault(FAULT_GET); err != nil {
+		return dst[:0], err
+	}
+
+	if k.getDoc == nil {
+		doc, err := NewDoc(nil, nil, nil)
+		if err != nil {
+			return dst[:0], err
+		}
+		k.getDoc = doc
+	}
+	doc := k.getDoc.doc
+
+	// release what the previous lookup read, keeping the key buffer
+	if doc.meta != nil {
+		C.free(doc.meta)
+	}
+	if doc.body != nil {
+		C.free(doc.body)
+	}
+	*doc = C.fdb_doc{key: doc.key}
+	if len(key) > k.getKeyCap {
+		C.free(doc.key)
+		doc.key = C.malloc(C.size_t(len(key)))
+		k.getKeyCap = len(key)
+	}
+	doc.keylen = C.size_t(len(key))
+	copy(goBytesNoCopy(doc.key, doc.keylen), key)
+
+	Log.Tracef("fdb_get call k:%p db:%p doc:%v", k, k.db, doc)
+	errNo := C.fdb_get(k.db, doc)
+	Log.Tracef("fdb_get retn k:%p errNo:%v doc:%v", k, errNo, doc)
+	if errNo != RESULT_SUCCESS {
+		return dst[:0], Error(errNo)
+	}
+
+	n := int(doc.bodylen)
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	dst = dst[:n]
+	copy(dst, goBytesNoCopy(doc.body, doc.bodylen))
+	return dst, nil
+}
+
 // SetKV simplified API for key/value access to Set()
 func (k *KVStore) SetKV(key, value []byte) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_SET); err != nil {
+		return err
+	}
 
 	var kk, v unsafe.Pointer
 
@@ -68,6 +135,12 @@ func (k *KVStore) SetKV(key, value []byte) error {
 
 // DeleteKV simplified API for key/value access to Delete()
 func (k *KVStore) DeleteKV(key []byte) error {
+	if err := k.checkHandle(); err != nil {
+		return err
+	}
+	if err := k.fault(FAULT_DELETE); err != nil {
+		return err
+	}
 
 	var kk unsafe.Pointer
 	if len(key) != 0 {
@@ -95,3 +95,57 @@ func TestForestDBKVCrud(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestForestDBKVGetInto(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	err = kvstore.SetKV([]byte("key1"), []byte("value1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.SetKV([]byte("key2"), []byte("a-longer-value2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 0, 10)
+	val, err := kvstore.GetInto([]byte("key1"), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "value1" {
+		t.Errorf("expected value1, got %s", val)
+	}
+	if &val[0] != &buf[:1][0] {
+		t.Errorf("expected buffer to be reused")
+	}
+
+	// value larger than the buffer
+	val, err = kvstore.GetInto([]byte("key2"), val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "a-longer-value2" {
+		t.Errorf("expected a-longer-value2, got %s", val)
+	}
+
+	val, err = kvstore.GetInto([]byte("doesnotexist"), val)
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+	if len(val) != 0 {
+		t.Errorf("expected empty value, got %s", val)
+	}
+}
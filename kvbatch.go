@@ -17,16 +17,43 @@ import (
 	"unsafe"
 )
 
+// BatchMode controls how ExecuteBatch applies a KVBatch
+type BatchMode uint8
+
+const (
+	// Apply all ops inside a single transaction (the default)
+	BATCH_TRANSACTION BatchMode = 0
+	// Apply all ops without a transaction and commit once at the end.
+	// If an op fails the preceding ops are not undone and remain
+	// pending until the next commit.
+	BATCH_GROUP_COMMIT BatchMode = 1
+)
+
 type batchOp struct {
-	del  bool
-	k    unsafe.Pointer
-	klen C.size_t
-	v    unsafe.Pointer
-	vlen C.size_t
+	del   bool
+	merge bool
+	// the merge function of a MergeNamed op, nil to use the KVStore's
+	mergeFn MergeFunc
+	kvs     *KVStore
+	k       unsafe.Pointer
+	klen    C.size_t
+	m       unsafe.Pointer
+	mlen    C.size_t
+	v       unsafe.Pointer
+	vlen    C.size_t
+	seq     SeqNum
 }
 
 type KVBatch struct {
-	ops []*batchOp
+	ops  []*batchOp
+	mode BatchMode
+	size int
+
+	flushKVS  *KVStore
+	flushSize int
+	flushOpt  CommitOpt
+	flushed   []SeqNum
+	err       error
 }
 
 func NewKVBatch() *KVBatch {
@@ -47,34 +74,172 @@ func copySliceToC(in []byte) (C.size_t, unsafe.Pointer) {
 	return outl, outv
 }
 
-func (b *KVBatch) Set(k, v []byte) {
-	bo := batchOp{del: false}
+// goBytesNoCopy returns a slice aliasing l bytes of C memory at p
+func goBytesNoCopy(p unsafe.Pointer, l C.size_t) []byte {
+	if p == nil {
+		return nil
+	}
+	var rv []byte
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&rv))
+	hdr.Data = uintptr(p)
+	hdr.Len = int(l)
+	hdr.Cap = int(l)
+	return rv
+}
+
+// SetMode changes how the batch is applied by ExecuteBatch
+func (b *KVBatch) SetMode(mode BatchMode) {
+	b.mode = mode
+}
+
+// SetAutoFlush makes the batch execute itself against kvs whenever
+// the total size of the queued keys, metadata and values reaches
+// maxSize bytes.  Each flush is applied (and committed) separately
+// using opt, so atomicity only holds within a flush.  An error from
+// an automatic flush is reported by Err and by the next ExecuteBatch.
+// A maxSize of 0 disables auto-flush.
+func (b *KVBatch) SetAutoFlush(kvs *KVStore, maxSize int, opt CommitOpt) {
+	b.flushKVS = kvs
+	b.flushSize = maxSize
+	b.flushOpt = opt
+}
+
+// Err returns the first error encountered by an automatic flush
+func (b *KVBatch) Err() error {
+	return b.err
+}
+
+// Len returns the number of ops queued in the batch
+func (b *KVBatch) Len() int {
+	return len(b.ops)
+}
+
+// Size returns the number of key, metadata and value bytes queued in the batch
+func (b *KVBatch) Size() int {
+	return b.size
+}
+
+// SeqNums returns the sequence number assigned to each op, in the order
+// the ops were added, for all ops executed since the last Reset
+func (b *KVBatch) SeqNums() []SeqNum {
+	rv := make([]SeqNum, 0, len(b.flushed)+len(b.ops))
+	rv = append(rv, b.flushed...)
+	for _, op := range b.ops {
+		rv = append(rv, op.seq)
+	}
+	return rv
+}
+
+func (b *KVBatch) add(kvs *KVStore, del bool, k, m, v []byte) {
+	b.addOp(&batchOp{del: del, kvs: kvs}, k, m, v)
+}
+
+func (b *KVBatch) addOp(bo *batchOp, k, m, v []byte) {
 	bo.klen, bo.k = copySliceToC(k)
+	if len(m) > 0 {
+		bo.mlen, bo.m = copySliceToC(m)
+	}
 	if len(v) > 0 {
 		bo.vlen, bo.v = copySliceToC(v)
 	}
-	b.ops = append(b.ops, &bo)
+	b.ops = append(b.ops, bo)
+	b.size += len(k) + len(m) + len(v)
+
+	if b.flushKVS != nil && b.flushSize > 0 && b.size >= b.flushSize && b.err == nil {
+		b.err = b.flushKVS.ExecuteBatch(b, b.flushOpt)
+		if b.err == nil {
+			b.flushed = append(b.flushed, b.SeqNums()[len(b.flushed):]...)
+			b.freeOps()
+		}
+	}
+}
+
+func (b *KVBatch) Set(k, v []byte) {
+	b.add(nil, false, k, nil, v)
 }
 
 func (b *KVBatch) Delete(k []byte) {
-	bo := batchOp{del: true}
-	bo.klen, bo.k = copySliceToC(k)
-	b.ops = append(b.ops, &bo)
+	b.add(nil, true, k, nil, nil)
 }
 
-func (b *KVBatch) Reset() {
+// SetMeta queues setting the metadata and value of a key
+func (b *KVBatch) SetMeta(k, m, v []byte) {
+	b.add(nil, false, k, m, v)
+}
+
+// SetDoc queues setting the key, metadata and body of doc
+func (b *KVBatch) SetDoc(doc *Doc) {
+	b.add(nil, false, doc.Key(), doc.Meta(), doc.Body())
+}
+
+// DeleteDoc queues deleting the key of doc
+func (b *KVBatch) DeleteDoc(doc *Doc) {
+	b.add(nil, true, doc.Key(), nil, nil)
+}
+
+// SetIn queues setting a key in the given KVStore instead of the one
+// the batch is executed against.  kvs must belong to the same File.
+func (b *KVBatch) SetIn(kvs *KVStore, k, m, v []byte) {
+	b.add(kvs, false, k, m, v)
+}
+
+// DeleteIn queues deleting a key from the given KVStore instead of the
+// one the batch is executed against.  kvs must belong to the same File.
+func (b *KVBatch) DeleteIn(kvs *KVStore, k []byte) {
+	b.add(kvs, true, k, nil, nil)
+}
+
+func (b *KVBatch) freeOps() {
 	for _, op := range b.ops {
 		if op.klen > 0 {
 			C.free(op.k)
 		}
+		if op.mlen > 0 {
+			C.free(op.m)
+		}
 		if op.vlen > 0 {
 			C.free(op.v)
 		}
 	}
 	b.ops = b.ops[:0]
+	b.size = 0
+}
+
+func (b *KVBatch) Reset() {
+	b.freeOps()
+	b.flushed = b.flushed[:0]
+	b.err = nil
 }
 
 func (k *KVStore) ExecuteBatch(b *KVBatch, opt CommitOpt) (err error) {
+	if b.err != nil {
+		return b.err
+	}
+	merges, defaultMerges := false, false
+	for _, op := range b.ops {
+		if op.kvs != nil && op.kvs.f != k.f {
+			return RESULT_INVALID_ARGS
+		}
+		merges = merges || op.merge
+		defaultMerges = defaultMerges || (op.merge && op.mergeFn == nil)
+	}
+	if merges {
+		// the merge operator is read under the lock, as
+		// SetMergeOperator may be changing it
+		k.writeMutex.Lock()
+		defer k.writeMutex.Unlock()
+		if defaultMerges && k.merge == nil {
+			return NoMergeOperator
+		}
+	}
+
+	if b.mode == BATCH_GROUP_COMMIT {
+		err = k.applyBatch(b)
+		if err != nil {
+			return
+		}
+		return k.File().Commit(opt)
+	}
 
 	err = k.File().BeginTransaction(ISOLATION_READ_COMMITTED)
 	if err != nil {
@@ -94,24 +259,73 @@ func (k *KVStore) ExecuteBatch(b *KVBatch, opt CommitOpt) (err error) {
 		}
 	}()
 
+	return k.applyBatch(b)
+}
+
+func (k *KVStore) applyBatch(b *KVBatch) error {
+	// a single scratch doc pointing at the C copies held by each op,
+	// so that fdb_set/fdb_del can report the assigned sequence number
+	doc := (*C.fdb_doc)(C.calloc(1, C.sizeof_fdb_doc))
+	defer C.free(unsafe.Pointer(doc))
+
 	for _, op := range b.ops {
+		kvs := k
+		if op.kvs != nil {
+			kvs = op.kvs
+		}
+		*doc = C.fdb_doc{}
+		doc.key = op.k
+		doc.keylen = op.klen
+		doc.meta = op.m
+		doc.metalen = op.mlen
+		doc.body = op.v
+		doc.bodylen = op.vlen
+
+		if op.merge {
+			fn := op.mergeFn
+			if fn == nil {
+				fn = kvs.merge
+			}
+			merged, err := kvs.mergeValue(fn, goBytesNoCopy(op.k, op.klen), goBytesNoCopy(op.v, op.vlen))
+			if err != nil {
+				return err
+			}
+			// the scratch doc is C memory and must not hold Go pointers
+			doc.bodylen, doc.body = copySliceToC(merged)
+		}
+
+		faultOp := FAULT_SET
 		if op.del {
-			Log.Tracef("fdb_del_kv call k:%p db:%p kk:%v", k, k.db, op.k)
-			errNo := C.fdb_del_kv(k.db, op.k, op.klen)
-			Log.Tracef("fdb_del_kv retn k:%p errNo:%v", k, errNo)
-			if errNo != RESULT_SUCCESS {
-				err = Error(errNo)
-				return
+			faultOp = FAULT_DELETE
+		}
+		err := kvs.checkHandle()
+		if err == nil {
+			err = kvs.fault(faultOp)
+		}
+		if err != nil {
+			if op.merge {
+				C.free(doc.body)
 			}
+			return err
+		}
+
+		var errNo C.fdb_status
+		if op.del {
+			Log.Tracef("fdb_del call k:%p db:%p kk:%v", kvs, kvs.db, op.k)
+			errNo = C.fdb_del(kvs.db, doc)
+			Log.Tracef("fdb_del retn k:%p errNo:%v seq:%v", kvs, errNo, doc.seqnum)
 		} else {
-			Log.Tracef("fdb_set_kv call k:%p db:%p kk:%v v:%v", k, k.db, op.k, op.v)
-			errNo := C.fdb_set_kv(k.db, op.k, op.klen, op.v, op.vlen)
-			Log.Tracef("fdb_set_kv retn k:%p errNo:%v", k, errNo)
-			if errNo != RESULT_SUCCESS {
-				err = Error(errNo)
-				return
-			}
+			Log.Tracef("fdb_set call k:%p db:%p kk:%v m:%v v:%v", kvs, kvs.db, op.k, op.m, op.v)
+			errNo = C.fdb_set(kvs.db, doc)
+			Log.Tracef("fdb_set retn k:%p errNo:%v seq:%v", kvs, errNo, doc.seqnum)
+		}
+		if op.merge {
+			C.free(doc.body)
+		}
+		if errNo != RESULT_SUCCESS {
+			return Error(errNo)
 		}
+		op.seq = SeqNum(doc.seqnum)
 	}
-	return
+	return nil
 }
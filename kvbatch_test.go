@@ -10,6 +10,7 @@ package forestdb
 
 import (
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -132,3 +133,128 @@ func TestForestDBKVBatch(t *testing.T) {
 	}
 
 }
+
+func TestForestDBKVBatchMetaSeqNums(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	other, err := dbfile.OpenKVStore("other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	doc, err := NewDoc([]byte("c"), []byte("c-meta"), []byte("c-val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	batch := NewKVBatch()
+	batch.SetMeta([]byte("a"), []byte("a-meta"), []byte("a-val"))
+	batch.Set([]byte("b"), []byte("b-val"))
+	batch.SetDoc(doc)
+	batch.SetIn(other, []byte("x"), nil, []byte("x-val"))
+	batch.Delete([]byte("b"))
+
+	err = kvstore.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seqs := batch.SeqNums()
+	expected := []SeqNum{1, 2, 3, 1, 4}
+	if !reflect.DeepEqual(seqs, expected) {
+		t.Errorf("expected seqnums %v, got %v", expected, seqs)
+	}
+
+	get, err := NewDoc([]byte("a"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer get.Close()
+	err = kvstore.Get(get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(get.Meta()) != "a-meta" || string(get.Body()) != "a-val" {
+		t.Errorf("expected a-meta/a-val, got %s/%s", get.Meta(), get.Body())
+	}
+
+	_, err = kvstore.GetKV([]byte("b"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+
+	val, err := other.GetKV([]byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "x-val" {
+		t.Errorf("expected x-val, got %s", val)
+	}
+}
+
+func TestForestDBKVBatchGroupCommitAutoFlush(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	batch := NewKVBatch()
+	batch.SetMode(BATCH_GROUP_COMMIT)
+	// each op is 2 bytes, so flush every 3 ops
+	batch.SetAutoFlush(kvstore, 6, COMMIT_NORMAL)
+	for i := 0; i < 8; i++ {
+		batch.Set([]byte{'k', byte('0' + i)}, nil)
+	}
+	if batch.Err() != nil {
+		t.Fatal(batch.Err())
+	}
+	if batch.Len() != 2 {
+		t.Errorf("expected 2 queued ops, got %d", batch.Len())
+	}
+
+	// flushed ops are visible before the final execute
+	_, err = kvstore.GetKV([]byte("k5"))
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = kvstore.GetKV([]byte("k6"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+
+	err = kvstore.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs := batch.SeqNums()
+	if len(seqs) != 8 || seqs[7] != 8 {
+		t.Errorf("expected 8 seqnums ending in 8, got %v", seqs)
+	}
+	_, err = kvstore.GetKV([]byte("k7"))
+	if err != nil {
+		t.Error(err)
+	}
+}
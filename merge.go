@@ -0,0 +1,192 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+)
+
+// MergeConflict selects which source wins when several sources
+// of a MergeIterator hold the same key
+type MergeConflict uint8
+
+const (
+	// The doc with the highest sequence number wins.  Only meaningful
+	// when the sources share a sequence number space, e.g. snapshots
+	// of the same KVStore.
+	MERGE_NEWEST_WINS MergeConflict = 0
+	// The doc from the source listed first wins, e.g. a delta
+	// KVStore listed before the base it overlays.
+	MERGE_FIRST_WINS MergeConflict = 1
+)
+
+type mergeHead struct {
+	valid   bool
+	key     []byte
+	seqnum  SeqNum
+	deleted bool
+}
+
+// MergeIterator combines several Iterators into a single stream in key
+// order, returning each key once.  A source ends when it reports
+// RESULT_ITERATOR_FAIL or RESULT_KEY_NOT_FOUND; any other error from a
+// source stops the MergeIterator and is returned from Get and Next.  To let deletions in one source hide
+// a key in another, the sources must be created without ITR_NO_DELETES;
+// with skipDeleted the MergeIterator then drops keys whose winning doc
+// is a deletion, otherwise it returns the deleted doc.
+type MergeIterator struct {
+	iters       []*Iterator
+	heads       []mergeHead
+	conflict    MergeConflict
+	skipDeleted bool
+	cur         int
+	// first error reported by a source, other than running out of docs
+	err error
+}
+
+// NewMergeIterator creates a MergeIterator over iters, which it takes
+// ownership of and closes in Close.
+func NewMergeIterator(iters []*Iterator, conflict MergeConflict, skipDeleted bool) *MergeIterator {
+	rv := MergeIterator{
+		iters:       iters,
+		heads:       make([]mergeHead, len(iters)),
+		conflict:    conflict,
+		skipDeleted: skipDeleted,
+		cur:         -1,
+	}
+	for n := range iters {
+		rv.load(n)
+	}
+	rv.position()
+	return &rv
+}
+
+// fail marks the n-th source exhausted, recording err unless it
+// just reports the end of the source
+func (i *MergeIterator) fail(n int, err error) {
+	i.heads[n] = mergeHead{}
+	if err != RESULT_ITERATOR_FAIL && err != RESULT_KEY_NOT_FOUND && i.err == nil {
+		i.err = err
+	}
+}
+
+// load records the position of the n-th source, or marks it exhausted
+func (i *MergeIterator) load(n int) {
+	i.heads[n] = mergeHead{}
+	doc, err := i.iters[n].GetMetaOnly()
+	if err != nil {
+		i.fail(n, err)
+		return
+	}
+	i.heads[n] = mergeHead{
+		valid:   true,
+		key:     doc.Key(),
+		seqnum:  doc.SeqNum(),
+		deleted: doc.Deleted(),
+	}
+	doc.Close()
+}
+
+// advance moves every source positioned at key past it
+func (i *MergeIterator) advance(key []byte) {
+	for n := range i.heads {
+		if i.heads[n].valid && bytes.Equal(i.heads[n].key, key) {
+			err := i.iters[n].Next()
+			if err != nil {
+				i.fail(n, err)
+			} else {
+				i.load(n)
+			}
+		}
+	}
+}
+
+// position selects the source supplying the smallest remaining key
+func (i *MergeIterator) position() {
+	for {
+		i.cur = -1
+		if i.err != nil {
+			return
+		}
+		for n, head := range i.heads {
+			if !head.valid {
+				continue
+			}
+			if i.cur < 0 {
+				i.cur = n
+				continue
+			}
+			switch bytes.Compare(head.key, i.heads[i.cur].key) {
+			case -1:
+				i.cur = n
+			case 0:
+				if i.conflict == MERGE_NEWEST_WINS && head.seqnum > i.heads[i.cur].seqnum {
+					i.cur = n
+				}
+			}
+		}
+		if i.cur < 0 || !i.skipDeleted || !i.heads[i.cur].deleted {
+			return
+		}
+		i.advance(i.heads[i.cur].key)
+	}
+}
+
+// Get gets the current item (key, metadata, doc body) from the iterator
+func (i *MergeIterator) Get() (*Doc, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	if i.cur < 0 {
+		return nil, RESULT_ITERATOR_FAIL
+	}
+	return i.iters[i.cur].Get()
+}
+
+// GetMetaOnly gets the current item (key, metadata, offset to doc body) from the iterator
+func (i *MergeIterator) GetMetaOnly() (*Doc, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	if i.cur < 0 {
+		return nil, RESULT_ITERATOR_FAIL
+	}
+	return i.iters[i.cur].GetMetaOnly()
+}
+
+// Next advances the iterator forward
+func (i *MergeIterator) Next() error {
+	if i.err != nil {
+		return i.err
+	}
+	if i.cur < 0 {
+		return RESULT_ITERATOR_FAIL
+	}
+	i.advance(i.heads[i.cur].key)
+	i.position()
+	if i.err != nil {
+		return i.err
+	}
+	if i.cur < 0 {
+		return RESULT_ITERATOR_FAIL
+	}
+	return nil
+}
+
+// Close all the source iterators
+func (i *MergeIterator) Close() (rverr error) {
+	for _, iter := range i.iters {
+		err := iter.Close()
+		if err != nil && rverr == nil {
+			rverr = err
+		}
+	}
+	return
+}
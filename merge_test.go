@@ -0,0 +1,143 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func collectMerge(iter *MergeIterator) (keys, vals []string) {
+	for {
+		doc, err := iter.Get()
+		if err != nil {
+			break
+		}
+		keys = append(keys, string(doc.Key()))
+		vals = append(vals, string(doc.Body()))
+		doc.Close()
+		if iter.Next() != nil {
+			break
+		}
+	}
+	return
+}
+
+func TestMergeIteratorOverlay(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	base, err := dbfile.OpenKVStore("base", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Close()
+
+	delta, err := dbfile.OpenKVStore("delta", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer delta.Close()
+
+	base.SetKV([]byte("a"), []byte("base-a"))
+	base.SetKV([]byte("b"), []byte("base-b"))
+	base.SetKV([]byte("c"), []byte("base-c"))
+	base.SetKV([]byte("e"), []byte("base-e"))
+	delta.SetKV([]byte("b"), []byte("delta-b"))
+	delta.SetKV([]byte("c"), []byte("delta-c"))
+	delta.DeleteKV([]byte("c"))
+	delta.SetKV([]byte("d"), []byte("delta-d"))
+
+	newIters := func() []*Iterator {
+		deltaIter, err := delta.IteratorInit(nil, nil, ITR_NONE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		baseIter, err := base.IteratorInit(nil, nil, ITR_NONE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []*Iterator{deltaIter, baseIter}
+	}
+
+	iter := NewMergeIterator(newIters(), MERGE_FIRST_WINS, true)
+	keys, vals := collectMerge(iter)
+	iter.Close()
+	if !reflect.DeepEqual(keys, []string{"a", "b", "d", "e"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if !reflect.DeepEqual(vals, []string{"base-a", "delta-b", "delta-d", "base-e"}) {
+		t.Errorf("unexpected values %v", vals)
+	}
+
+	// without skipping, the tombstone for c is returned
+	iter = NewMergeIterator(newIters(), MERGE_FIRST_WINS, false)
+	keys, _ = collectMerge(iter)
+	iter.Close()
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestMergeIteratorNewestWins(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	dbfile, err := Open("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbfile.Close()
+
+	kvstore, err := dbfile.OpenKVStoreDefault(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvstore.Close()
+
+	kvstore.SetKV([]byte("a"), []byte("old-a"))
+	kvstore.SetKV([]byte("b"), []byte("old-b"))
+	dbfile.Commit(COMMIT_NORMAL)
+
+	old, err := kvstore.SnapshotOpen(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	kvstore.SetKV([]byte("a"), []byte("new-a"))
+	kvstore.SetKV([]byte("c"), []byte("new-c"))
+	dbfile.Commit(COMMIT_NORMAL)
+
+	oldIter, err := old.IteratorInit(nil, nil, ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newIter, err := kvstore.IteratorInit(nil, nil, ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// list the old snapshot first so that seqnums, not order, decide
+	iter := NewMergeIterator([]*Iterator{oldIter, newIter}, MERGE_NEWEST_WINS, true)
+	defer iter.Close()
+	keys, vals := collectMerge(iter)
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if !reflect.DeepEqual(vals, []string{"new-a", "old-b", "new-c"}) {
+		t.Errorf("unexpected values %v", vals)
+	}
+}
@@ -0,0 +1,222 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MergeFunc combines the current value of a key with a merge operand and
+// returns the new value.  existing is nil if the key does not exist.
+// Neither slice may be retained after the function returns.
+type MergeFunc func(existing, operand []byte) ([]byte, error)
+
+var NoMergeOperator = fmt.Errorf("no merge operator set on kvstore")
+var UnknownMergeOperator = fmt.Errorf("unknown merge operator")
+var InvalidMergeValue = fmt.Errorf("invalid value for merge operator")
+
+// The built-in merge operators
+const (
+	// Adds int64 operands to an int64 value, see Int64Value
+	MERGE_INT64_ADD = "int64add"
+	// Keeps the larger of the int64 value and operand
+	MERGE_INT64_MAX = "int64max"
+	// Appends the operand to the value
+	MERGE_APPEND = "append"
+	// Adds the members of the operand set to the value set, see SetValue
+	MERGE_SET_UNION = "setunion"
+)
+
+var mergeFuncsMutex sync.RWMutex
+var mergeFuncs = map[string]MergeFunc{
+	MERGE_INT64_ADD: mergeInt64Add,
+	MERGE_INT64_MAX: mergeInt64Max,
+	MERGE_APPEND:    mergeAppend,
+	MERGE_SET_UNION: mergeSetUnion,
+}
+
+// RegisterMergeFunc makes a merge function available under name, to
+// be selected with SetMergeOperator, replacing any previous one
+func RegisterMergeFunc(name string, fn MergeFunc) {
+	mergeFuncsMutex.Lock()
+	defer mergeFuncsMutex.Unlock()
+	mergeFuncs[name] = fn
+}
+
+// SetMergeOperator selects the registered merge function used by Merge
+// and by the merge ops of batches executed against this KVStore
+func (k *KVStore) SetMergeOperator(name string) error {
+	mergeFuncsMutex.RLock()
+	fn, ok := mergeFuncs[name]
+	mergeFuncsMutex.RUnlock()
+	if !ok {
+		return UnknownMergeOperator
+	}
+	k.writeMutex.Lock()
+	k.merge = fn
+	k.writeMutex.Unlock()
+	return nil
+}
+
+// mergeValue applies the merge function fn to the current value of key
+func (k *KVStore) mergeValue(fn MergeFunc, key, operand []byte) ([]byte, error) {
+	existing, err := k.GetKV(key)
+	if err == RESULT_KEY_NOT_FOUND {
+		existing = nil
+	} else if err != nil {
+		return nil, err
+	}
+	return fn(existing, operand)
+}
+
+// Merge atomically combines operand with the current value of key using
+// the KVStore's merge operator and stores the result.  Merges through
+// the same KVStore are serialized, so concurrent merges are never lost.
+func (k *KVStore) Merge(key, operand []byte) error {
+	k.writeMutex.Lock()
+	defer k.writeMutex.Unlock()
+
+	if k.merge == nil {
+		return NoMergeOperator
+	}
+	merged, err := k.mergeValue(k.merge, key, operand)
+	if err != nil {
+		return err
+	}
+	return k.SetKV(key, merged)
+}
+
+// Merge queues merging operand into the value of a key.  Merges are
+// applied in order with the other ops of the batch, using the merge
+// operator of the KVStore the batch is executed against.
+func (b *KVBatch) Merge(k, operand []byte) {
+	b.addOp(&batchOp{merge: true}, k, nil, operand)
+}
+
+// MergeNamed is like Merge but uses the merge function registered under
+// name rather than the operator of the KVStore.  If there is none, the
+// batch fails with UnknownMergeOperator when executed.
+func (b *KVBatch) MergeNamed(name string, k, operand []byte) {
+	mergeFuncsMutex.RLock()
+	fn, ok := mergeFuncs[name]
+	mergeFuncsMutex.RUnlock()
+	if !ok {
+		if b.err == nil {
+			b.err = UnknownMergeOperator
+		}
+		return
+	}
+	b.addOp(&batchOp{merge: true, mergeFn: fn}, k, nil, operand)
+}
+
+// Int64Value encodes v as a value for the int64 merge operators
+func Int64Value(v int64) []byte {
+	rv := make([]byte, 8)
+	binary.BigEndian.PutUint64(rv, uint64(v))
+	return rv
+}
+
+// ValueInt64 decodes a value of the int64 merge operators
+func ValueInt64(value []byte) (int64, error) {
+	if len(value) != 8 {
+		return 0, InvalidMergeValue
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
+
+// SetValue encodes members as a value for the set union merge operator
+func SetValue(members [][]byte) []byte {
+	sorted := make([][]byte, len(members))
+	copy(sorted, members)
+	sort.Sort(byteSlices(sorted))
+
+	buf := &bytes.Buffer{}
+	var lenBuf [binary.MaxVarintLen64]byte
+	for i, member := range sorted {
+		if i > 0 && bytes.Equal(member, sorted[i-1]) {
+			continue
+		}
+		n := binary.PutUvarint(lenBuf[:], uint64(len(member)))
+		buf.Write(lenBuf[:n])
+		buf.Write(member)
+	}
+	return buf.Bytes()
+}
+
+// ValueSet decodes a value of the set union merge operator
+func ValueSet(value []byte) ([][]byte, error) {
+	var rv [][]byte
+	for len(value) > 0 {
+		l, n := binary.Uvarint(value)
+		if n <= 0 || l > uint64(len(value)-n) {
+			return nil, InvalidMergeValue
+		}
+		value = value[n:]
+		member := make([]byte, l)
+		copy(member, value)
+		rv = append(rv, member)
+		value = value[l:]
+	}
+	return rv, nil
+}
+
+func mergeInt64Add(existing, operand []byte) ([]byte, error) {
+	delta, err := ValueInt64(operand)
+	if err != nil {
+		return nil, err
+	}
+	var v int64
+	if existing != nil {
+		v, err = ValueInt64(existing)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return Int64Value(v + delta), nil
+}
+
+func mergeInt64Max(existing, operand []byte) ([]byte, error) {
+	o, err := ValueInt64(operand)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		v, err := ValueInt64(existing)
+		if err != nil {
+			return nil, err
+		}
+		if v > o {
+			o = v
+		}
+	}
+	return Int64Value(o), nil
+}
+
+func mergeAppend(existing, operand []byte) ([]byte, error) {
+	rv := make([]byte, 0, len(existing)+len(operand))
+	rv = append(rv, existing...)
+	return append(rv, operand...), nil
+}
+
+func mergeSetUnion(existing, operand []byte) ([]byte, error) {
+	members, err := ValueSet(existing)
+	if err != nil {
+		return nil, err
+	}
+	added, err := ValueSet(operand)
+	if err != nil {
+		return nil, err
+	}
+	return SetValue(append(members, added...)), nil
+}
@@ -0,0 +1,212 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMergeInt64Add(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	err = kvstore.Merge([]byte("counter"), Int64Value(1))
+	if err != NoMergeOperator {
+		t.Errorf("expected NoMergeOperator, got %v", err)
+	}
+	err = kvstore.SetMergeOperator("nosuch")
+	if err != UnknownMergeOperator {
+		t.Errorf("expected UnknownMergeOperator, got %v", err)
+	}
+	err = kvstore.SetMergeOperator(MERGE_INT64_ADD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 25; n++ {
+				err := kvstore.Merge([]byte("counter"), Int64Value(2))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := kvstore.GetKV([]byte("counter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := ValueInt64(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 200 {
+		t.Errorf("expected 200, got %d", count)
+	}
+
+	err = kvstore.Merge([]byte("counter"), []byte("bad"))
+	if err != InvalidMergeValue {
+		t.Errorf("expected InvalidMergeValue, got %v", err)
+	}
+}
+
+func TestMergeBatch(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	err = kvstore.SetMergeOperator(MERGE_SET_UNION)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewKVBatch()
+	defer batch.Reset()
+	batch.Merge([]byte("tags"), SetValue([][]byte{[]byte("b"), []byte("a")}))
+	batch.Merge([]byte("tags"), SetValue([][]byte{[]byte("c"), []byte("a")}))
+	batch.Set([]byte("other"), []byte("val"))
+	err = kvstore.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := kvstore.GetKV([]byte("tags"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err := ValueSet(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	if !reflect.DeepEqual(members, expected) {
+		t.Errorf("expected %q, got %q", expected, members)
+	}
+
+	// a failing merge aborts the whole batch
+	batch.Reset()
+	batch.Set([]byte("other"), []byte("changed"))
+	batch.Merge([]byte("tags"), []byte{0xff})
+	err = kvstore.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != InvalidMergeValue {
+		t.Errorf("expected InvalidMergeValue, got %v", err)
+	}
+	val, err = kvstore.GetKV([]byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "val" {
+		t.Errorf("expected val, got %s", val)
+	}
+}
+
+func TestMergeBatchNamed(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// named merges need no merge operator on the KVStore
+	batch := NewKVBatch()
+	defer batch.Reset()
+	batch.MergeNamed(MERGE_INT64_ADD, []byte("count"), Int64Value(2))
+	batch.MergeNamed(MERGE_APPEND, []byte("log"), []byte("a"))
+	batch.MergeNamed(MERGE_INT64_ADD, []byte("count"), Int64Value(3))
+	batch.MergeNamed(MERGE_APPEND, []byte("log"), []byte("b"))
+	err = kvstore.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := kvstore.GetKV([]byte("count"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := ValueInt64(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5, got %d", count)
+	}
+	val, err = kvstore.GetKV([]byte("log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "ab" {
+		t.Errorf("expected ab, got %s", val)
+	}
+
+	// but unnamed ones still do
+	batch.Reset()
+	batch.Merge([]byte("log"), []byte("c"))
+	err = kvstore.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != NoMergeOperator {
+		t.Errorf("expected NoMergeOperator, got %v", err)
+	}
+
+	batch.Reset()
+	batch.MergeNamed("no-such-operator", []byte("log"), []byte("c"))
+	err = kvstore.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != UnknownMergeOperator {
+		t.Errorf("expected UnknownMergeOperator, got %v", err)
+	}
+}
+
+func TestMergeFuncs(t *testing.T) {
+	RegisterMergeFunc("prepend", func(existing, operand []byte) ([]byte, error) {
+		return append(append([]byte{}, operand...), existing...), nil
+	})
+
+	tests := []struct {
+		name     string
+		existing []byte
+		operand  []byte
+		expected []byte
+	}{
+		{MERGE_INT64_ADD, nil, Int64Value(-3), Int64Value(-3)},
+		{MERGE_INT64_ADD, Int64Value(5), Int64Value(-3), Int64Value(2)},
+		{MERGE_INT64_MAX, Int64Value(5), Int64Value(3), Int64Value(5)},
+		{MERGE_INT64_MAX, Int64Value(5), Int64Value(7), Int64Value(7)},
+		{MERGE_APPEND, []byte("ab"), []byte("cd"), []byte("abcd")},
+		{MERGE_APPEND, nil, []byte("cd"), []byte("cd")},
+		{"prepend", []byte("ab"), []byte("cd"), []byte("cdab")},
+	}
+	for _, test := range tests {
+		merged, err := mergeFuncs[test.name](test.existing, test.operand)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(merged, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, merged)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// number of keys sampled per partition when splitting a range
+const parallelScanSamples = 32
+
+// ParallelScanFunc is called by ParallelScan for every doc, concurrently
+// from the worker goroutines.  The doc is in view mode and is only valid
+// until the function returns.
+type ParallelScanFunc func(worker int, doc *Doc) error
+
+// ParallelScan calls fn for every non-deleted doc between startKey and
+// endKey (inclusive) using up to workers goroutines.  The range is split
+// into partitions holding roughly equal numbers of docs by sampling keys
+// through the sequence index (which must be enabled), and every worker
+// scans its partition, in key order, through its own clone of a single
+// in-memory snapshot, so all workers observe the same consistent state.
+// The first error returned by fn, or encountered by a worker, stops the
+// scan and is returned.
+func (k *KVStore) ParallelScan(startKey, endKey []byte, workers int, fn ParallelScanFunc) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	base, err := k.SnapshotOpen(SnapshotInmem)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	splits, err := base.sampleSplits(startKey, endKey, workers)
+	if err != nil {
+		return err
+	}
+
+	// clone the snapshot for each partition up front, as the base
+	// handle must not be used concurrently
+	snaps := make([]*KVStore, 0, len(splits)+1)
+	defer func() {
+		for _, snap := range snaps {
+			snap.Close()
+		}
+	}()
+	for i := 0; i <= len(splits); i++ {
+		snap, err := base.SnapshotOpen(SnapshotInmem)
+		if err != nil {
+			return err
+		}
+		snaps = append(snaps, snap)
+	}
+
+	var stop int32
+	errs := make([]error, len(snaps))
+	var wg sync.WaitGroup
+	for i, snap := range snaps {
+		lo, hi, opt := startKey, endKey, ITR_NO_DELETES
+		if i > 0 {
+			lo = splits[i-1]
+		}
+		if i < len(splits) {
+			// the split key belongs to the next partition
+			hi = splits[i]
+			opt |= FDB_ITR_SKIP_MAX_KEY
+		}
+		wg.Add(1)
+		go func(i int, snap *KVStore, lo, hi []byte, opt IteratorOpt) {
+			defer wg.Done()
+			errs[i] = scanPartition(snap, lo, hi, opt, &stop, func(doc *Doc) error {
+				return fn(i, doc)
+			})
+			if errs[i] != nil {
+				atomic.StoreInt32(&stop, 1)
+			}
+		}(i, snap, lo, hi, opt)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanPartition(snap *KVStore, lo, hi []byte, opt IteratorOpt, stop *int32, fn func(*Doc) error) error {
+	iter, err := snap.IteratorInit(lo, hi, opt)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for atomic.LoadInt32(stop) == 0 {
+		doc, err := iter.GetView()
+		if isIterEnd(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = fn(doc)
+		if err != nil {
+			return err
+		}
+		if iter.Next() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// sampleSplits picks up to n-1 increasing keys above startKey and within
+// the range which split it into partitions of similar size, by looking up
+// the keys of randomly chosen sequence numbers.  A range narrow relative
+// to the KVStore holds too few of those keys to be split by them, so it
+// is then split with bisectRange instead, as is any range of a KVStore
+// without a sequence index.
+func (k *KVStore) sampleSplits(startKey, endKey []byte, n int) ([][]byte, error) {
+	if n < 2 {
+		return nil, nil
+	}
+	var keys [][]byte
+	err := k.sampleBySeq(n*parallelScanSamples, func(doc *Doc) {
+		key := doc.Key()
+		if keyInRange(key, startKey, endKey) && !bytes.Equal(key, startKey) {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil && err != RESULT_INVALID_CONFIG {
+		return nil, err
+	}
+	if err != nil || len(keys) < parallelScanSamples {
+		return k.bisectRange(startKey, endKey, n)
+	}
+
+	sort.Sort(byteSlices(keys))
+	var splits [][]byte
+	for i := 1; i < n && len(keys) > 0; i++ {
+		key := keys[len(keys)*i/n]
+		if len(splits) == 0 || bytes.Compare(key, splits[len(splits)-1]) > 0 {
+			splits = append(splits, key)
+		}
+	}
+	return splits, nil
+}
+
+// bisectRange picks up to n-1 increasing keys of the range, other than its
+// first key, by repeatedly splitting the intervals between the keys found
+// so far, starting with the first and last key of the range, at the key
+// nearest to the midpoint of their bounds.  The intervals are split in
+// breadth-first order, so they are balanced in key space, though not
+// necessarily in the number of docs they hold.
+func (k *KVStore) bisectRange(startKey, endKey []byte, n int) ([][]byte, error) {
+	iter, err := k.IteratorInit(startKey, endKey, ITR_NO_DELETES)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	first, err := iterKeyAfter(iter, iter.SeekMin)
+	if isIterEnd(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	last, err := iterKeyAfter(iter, iter.SeekMax)
+	if err != nil {
+		return nil, err
+	}
+
+	var splits [][]byte
+	intervals := [][2][]byte{{first, last}}
+	for len(splits) < n-1 && len(intervals) > 0 {
+		lo, hi := intervals[0][0], intervals[0][1]
+		intervals = intervals[1:]
+
+		mid, err := iterKeyBetween(iter, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		if mid == nil {
+			continue
+		}
+		splits = append(splits, mid)
+		intervals = append(intervals, [2][]byte{lo, mid}, [2][]byte{mid, hi})
+	}
+
+	sort.Sort(byteSlices(splits))
+	return splits, nil
+}
+
+// iterKeyBetween returns the key of iter nearest to the midpoint of lo and
+// hi and strictly between them, or nil if there is none
+func iterKeyBetween(iter *Iterator, lo, hi []byte) ([]byte, error) {
+	mid := midpointKey(lo, hi)
+	for _, dir := range []SeekOpt{FDB_ITR_SEEK_HIGHER, FDB_ITR_SEEK_LOWER} {
+		key, err := iterKeyAfter(iter, func() error {
+			return iter.Seek(mid, dir)
+		})
+		if isIterEnd(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if bytes.Compare(key, lo) > 0 && bytes.Compare(key, hi) < 0 {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// iterKeyAfter moves iter with move and returns the key it lands on
+func iterKeyAfter(iter *Iterator, move func() error) ([]byte, error) {
+	err := move()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := iter.GetMetaOnly()
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+	return doc.Key(), nil
+}
+
+// midpointKey returns the common prefix of lo and hi followed by the
+// midpoint of the 8 bytes after it in lo and in hi
+func midpointKey(lo, hi []byte) []byte {
+	prefix := 0
+	for prefix < len(lo) && prefix < len(hi) && lo[prefix] == hi[prefix] {
+		prefix++
+	}
+	var a, b [8]byte
+	copy(a[:], lo[prefix:])
+	copy(b[:], hi[prefix:])
+	x, y := binary.BigEndian.Uint64(a[:]), binary.BigEndian.Uint64(b[:])
+
+	rv := make([]byte, prefix+8)
+	copy(rv, lo[:prefix])
+	binary.BigEndian.PutUint64(rv[prefix:], x+(y-x)/2)
+	return rv
+}
+
+// number of sequence numbers sampleBySeq may look up per sample wanted
+const sampleBySeqProbes = 64
+
+// sampleBySeq calls fn with the metadata of up to samples live docs,
+// chosen uniformly at random by looking up random sequence numbers.  Only
+// the latest sequence number of each doc can be found, so after many
+// updates most lookups miss: they are repeated until samples docs were
+// found or samples*sampleBySeqProbes lookups were made.  Any error other
+// than a miss stops the sampling; with the sequence index disabled the
+// first lookup fails with RESULT_INVALID_CONFIG.
+func (k *KVStore) sampleBySeq(samples int, fn func(doc *Doc)) error {
+	info, err := k.Info()
+	if err != nil {
+		return err
+	}
+	last := int64(info.LastSeqNum())
+	if last == 0 {
+		return nil
+	}
+
+	found := 0
+	for probes := 0; found < samples && probes < samples*sampleBySeqProbes; probes++ {
+		doc, err := NewDoc(nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		doc.SetSeqNum(SeqNum(rand.Int63n(last) + 1))
+		err = k.GetMetaOnlyBySeq(doc)
+		if err == nil && !doc.Deleted() {
+			found++
+			fn(doc)
+		}
+		doc.Close()
+		if err != nil && err != RESULT_KEY_NOT_FOUND {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyInRange returns whether key lies between the inclusive bounds,
+// where an empty bound is unbounded, as with IteratorInit
+func keyInRange(key, startKey, endKey []byte) bool {
+	return (len(startKey) == 0 || bytes.Compare(key, startKey) >= 0) &&
+		(len(endKey) == 0 || bytes.Compare(key, endKey) <= 0)
+}
+
+type byteSlices [][]byte
+
+func (b byteSlices) Len() int           { return len(b) }
+func (b byteSlices) Less(i, j int) bool { return bytes.Compare(b[i], b[j]) < 0 }
+func (b byteSlices) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
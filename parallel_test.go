@@ -0,0 +1,108 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestParallelScan(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		err = kvstore.SetKV(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = kvstore.DeleteKV([]byte("key0500"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m sync.Mutex
+	seen := make(map[string]int)
+	workers := make(map[int]int)
+	err = kvstore.ParallelScan([]byte("key0100"), []byte("key0899"), 4, func(worker int, doc *Doc) error {
+		if string(doc.Body()) != string(doc.Key()) {
+			return fmt.Errorf("unexpected body %s for key %s", doc.Body(), doc.Key())
+		}
+		m.Lock()
+		seen[string(doc.Key())]++
+		workers[worker]++
+		m.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 799 {
+		t.Errorf("expected 799 keys, saw %d", len(seen))
+	}
+	for key, count := range seen {
+		if count != 1 {
+			t.Errorf("key %s seen %d times", key, count)
+		}
+	}
+	if seen["key0500"] != 0 || seen["key0099"] != 0 || seen["key0900"] != 0 {
+		t.Errorf("saw keys outside the range or deleted")
+	}
+	if len(workers) < 2 {
+		t.Errorf("expected the range to be split, used %d workers", len(workers))
+	}
+
+	// a range too narrow to hold keys sampled from the whole KVStore
+	// is still split
+	seen = make(map[string]int)
+	workers = make(map[int]int)
+	err = kvstore.ParallelScan([]byte("key0100"), []byte("key0119"), 4, func(worker int, doc *Doc) error {
+		m.Lock()
+		seen[string(doc.Key())]++
+		workers[worker]++
+		m.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 20 {
+		t.Errorf("expected 20 keys, saw %d", len(seen))
+	}
+	for key, count := range seen {
+		if count != 1 {
+			t.Errorf("key %s seen %d times", key, count)
+		}
+	}
+	if len(workers) < 2 {
+		t.Errorf("expected the narrow range to be split, used %d workers", len(workers))
+	}
+
+	stopErr := fmt.Errorf("stop")
+	err = kvstore.ParallelScan(nil, nil, 4, func(worker int, doc *Doc) error {
+		return stopErr
+	})
+	if err != stopErr {
+		t.Errorf("expected %v, got %v", stopErr, err)
+	}
+}
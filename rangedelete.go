@@ -0,0 +1,126 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+)
+
+// DefaultDeleteRangeChunkSize is the number of keys deleted per
+// transaction by DeleteRange when no chunk size is given
+const DefaultDeleteRangeChunkSize = 1000
+
+// DeleteRangeProgress is called by DeleteRange after each chunk
+// has been committed, with the total number of keys deleted so far
+type DeleteRangeProgress func(deleted uint64)
+
+// DeleteRange deletes all keys between startKey and endKey (inclusive),
+// committing a transaction for every chunkSize keys, and returns the
+// number of keys deleted.  Each chunk is atomic, but the range as a whole
+// is not: if an error is returned, the chunks reported to progress
+// (which may be nil) remain deleted.
+func (k *KVStore) DeleteRange(startKey, endKey []byte, chunkSize int, progress DeleteRangeProgress) (uint64, error) {
+	if chunkSize < 1 {
+		chunkSize = DefaultDeleteRangeChunkSize
+	}
+
+	var deleted uint64
+	opt := ITR_NO_DELETES
+	batch := NewKVBatch()
+	defer batch.Reset()
+	for {
+		batch.Reset()
+		last, err := k.collectDeletes(batch, startKey, endKey, opt, chunkSize)
+		if err != nil {
+			return deleted, err
+		}
+		if batch.Len() == 0 {
+			return deleted, nil
+		}
+
+		err = k.ExecuteBatch(batch, COMMIT_NORMAL)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += uint64(batch.Len())
+		if progress != nil {
+			progress(deleted)
+		}
+		if batch.Len() < chunkSize {
+			return deleted, nil
+		}
+
+		// resume after the last key deleted
+		startKey = last
+		opt |= FDB_ITR_SKIP_MIN_KEY
+	}
+}
+
+// collectDeletes queues deletes for up to max keys of the range and
+// returns the last key queued.  The iterator is closed before the
+// batch is executed, so it never overlaps the transaction.
+func (k *KVStore) collectDeletes(b *KVBatch, startKey, endKey []byte, opt IteratorOpt, max int) ([]byte, error) {
+	iter, err := k.IteratorInit(startKey, endKey, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var last []byte
+	for b.Len() < max {
+		doc, err := iter.GetMetaOnly()
+		if isIterEnd(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		last = doc.Key()
+		doc.Close()
+		b.Delete(last)
+		if iter.Next() != nil {
+			break
+		}
+	}
+	return last, nil
+}
+
+// Truncate removes all keys from the KVStore.  A named KVStore is
+// dropped with RemoveKVStore and recreated with the config it was opened
+// with, which requires that no other handle on it is open.  The default
+// KVStore cannot be removed, so its keys are deleted with DeleteRange.
+//
+// If a named KVStore cannot be reopened, Truncate returns an error saying
+// so, which wraps the Error from reopening, and the KVStore is left without a handle: its operations fail with
+// RESULT_INVALID_HANDLE and Close does nothing.
+func (k *KVStore) Truncate() error {
+	if k.f == nil {
+		// snapshots are read-only
+		return RESULT_RONLY_VIOLATION
+	}
+	if k.name == "default" {
+		_, err := k.DeleteRange(nil, nil, 0, nil)
+		return err
+	}
+
+	err := k.Close()
+	if err != nil {
+		return err
+	}
+	k.db = nil
+	removeErr := k.f.RemoveKVStore(k.name)
+
+	// reopen even if the removal failed, so the handle remains usable
+	reopened, err := k.f.OpenKVStore(k.name, k.config)
+	if err != nil {
+		return fmt.Errorf("truncate of kvstore %s failed to reopen it, the kvstore is now unusable: %w", k.name, err)
+	}
+	k.db = reopened.db
+	return removeErr
+}
@@ -0,0 +1,144 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDeleteRange(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for i := 0; i < 100; i++ {
+		err = kvstore.SetKV([]byte(fmt.Sprintf("key%03d", i)), []byte("val"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reported []uint64
+	deleted, err := kvstore.DeleteRange([]byte("key010"), []byte("key059"), 20, func(n uint64) {
+		reported = append(reported, n)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 50 {
+		t.Errorf("expected 50 deleted, got %d", deleted)
+	}
+	if !reflect.DeepEqual(reported, []uint64{20, 40, 50}) {
+		t.Errorf("unexpected progress %v", reported)
+	}
+
+	docs, _, err := kvstore.CountRange(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs != 50 {
+		t.Errorf("expected 50 docs left, got %d", docs)
+	}
+	_, err = kvstore.GetKV([]byte("key009"))
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = kvstore.GetKV([]byte("key060"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = kvstore.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, _, err = kvstore.CountRange(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs != 0 {
+		t.Errorf("expected no docs left, got %d", docs)
+	}
+}
+
+func TestTruncateNamed(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "tenant", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for i := 0; i < 100; i++ {
+		err = kvstore.SetKV([]byte(fmt.Sprintf("key%03d", i)), []byte("val"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = kvstore.File().Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvstore.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = kvstore.GetKV([]byte("key000"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+	// the recreated KVStore is usable through the same handle
+	err = kvstore.SetKV([]byte("key000"), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := kvstore.GetKV([]byte("key000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "new" {
+		t.Errorf("expected new, got %s", val)
+	}
+}
+
+func TestTruncateLostHandle(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "tenant", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	// the state a failed reopen in Truncate leaves behind
+	lost := KVStore{f: kvstore.f, name: kvstore.name}
+	_, err = lost.GetKV([]byte("key000"))
+	if err != RESULT_INVALID_HANDLE {
+		t.Errorf("expected %v, got %v", RESULT_INVALID_HANDLE, err)
+	}
+	_, err = lost.Info()
+	if err != RESULT_INVALID_HANDLE {
+		t.Errorf("expected %v, got %v", RESULT_INVALID_HANDLE, err)
+	}
+	err = lost.Close()
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
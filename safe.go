@@ -0,0 +1,309 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// SafeKVStore is a KVStore which may be used from many goroutines at once.
+// All writes go through a single writer handle, serialized by a mutex.
+// Writes only mark the store dirty; the first read after a write
+// publishes an in-memory snapshot of the writer's state.  Reads are
+// served by a fixed set of reader handles, each cloned from the latest
+// published snapshot when it is found to be stale, so a read always
+// observes every write that completed before it started.  A read that
+// needs a fresh snapshot waits for a write in progress to finish.
+type SafeKVStore struct {
+	// number of writes issued through the writer, accessed atomically
+	written uint64
+
+	closedMutex sync.RWMutex
+	closed      bool
+
+	writeMutex sync.Mutex
+	writer     *KVStore
+
+	// guards the published snapshot, which is only used to clone
+	// reader handles
+	baseMutex   sync.Mutex
+	base        *KVStore
+	baseGen     uint64
+	baseSeq     SeqNum
+	baseWritten uint64
+
+	readers chan *safeReader
+}
+
+type safeReader struct {
+	snap    *KVStore
+	gen     uint64
+	written uint64
+}
+
+var SafeKVStoreClosed = fmt.Errorf("safe kvstore already closed")
+
+// NewSafeKVStore opens the named KVStore in filename and prepares
+// readers snapshot handles for concurrent reads.
+func NewSafeKVStore(filename string, config *Config, kvstore string, kvconfig *KVStoreConfig, readers int) (*SafeKVStore, error) {
+	if readers < 1 {
+		readers = 1
+	}
+	writer, err := OpenFileKVStore(filename, config, kvstore, kvconfig)
+	if err != nil {
+		return nil, err
+	}
+	rv := SafeKVStore{
+		writer:  writer,
+		readers: make(chan *safeReader, readers),
+	}
+	for i := 0; i < readers; i++ {
+		rv.readers <- &safeReader{}
+	}
+	return &rv, nil
+}
+
+// publish brings the published snapshot up to date with the writer.
+// A new snapshot is only opened if the writer's sequence number moved
+// since the last one, so failed writes do not force readers to re-clone.
+// The caller must hold baseMutex.
+func (s *SafeKVStore) publish() error {
+	written := atomic.LoadUint64(&s.written)
+	if s.base != nil && s.baseWritten == written {
+		return nil
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	written = atomic.LoadUint64(&s.written)
+	info, err := s.writer.Info()
+	if err != nil {
+		return err
+	}
+	if s.base != nil && s.baseSeq == info.LastSeqNum() {
+		s.baseWritten = written
+		return nil
+	}
+	snap, err := s.writer.SnapshotOpen(SnapshotInmem)
+	if err != nil {
+		return err
+	}
+	snap.f = s.writer.f
+	snap.name = s.writer.name
+
+	if s.base != nil {
+		s.base.Close()
+	}
+	s.base = snap
+	s.baseGen++
+	s.baseSeq = info.LastSeqNum()
+	s.baseWritten = written
+	return nil
+}
+
+// clone opens a private handle on the published snapshot, unless the
+// reader r already holds one of the same generation.  r may be nil.
+func (s *SafeKVStore) clone(r *safeReader) (*KVStore, uint64, uint64, error) {
+	s.baseMutex.Lock()
+	defer s.baseMutex.Unlock()
+	err := s.publish()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if r != nil && r.snap != nil && r.gen == s.baseGen {
+		return r.snap, s.baseGen, s.baseWritten, nil
+	}
+	snap, err := s.base.SnapshotOpen(SnapshotInmem)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	snap.f = s.base.f
+	snap.name = s.base.name
+	return snap, s.baseGen, s.baseWritten, nil
+}
+
+func (s *SafeKVStore) getReader() (*safeReader, error) {
+	s.closedMutex.RLock()
+	if s.closed {
+		s.closedMutex.RUnlock()
+		return nil, SafeKVStoreClosed
+	}
+	r := <-s.readers
+	s.closedMutex.RUnlock()
+
+	if r.snap != nil && r.written == atomic.LoadUint64(&s.written) {
+		return r, nil
+	}
+	snap, gen, written, err := s.clone(r)
+	if err != nil {
+		s.readers <- r
+		return nil, err
+	}
+	if r.snap != nil && r.snap != snap {
+		r.snap.Close()
+	}
+	r.snap = snap
+	r.gen = gen
+	r.written = written
+	return r, nil
+}
+
+func (s *SafeKVStore) write(f func(*KVStore) error) error {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+	if s.closed {
+		return SafeKVStoreClosed
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	// even a failed write may have changed the state visible to readers,
+	// so the next read checks whether the writer moved on
+	defer atomic.AddUint64(&s.written, 1)
+	return f(s.writer)
+}
+
+// GetKV returns the value for key
+func (s *SafeKVStore) GetKV(key []byte) ([]byte, error) {
+	r, err := s.getReader()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { s.readers <- r }()
+	return r.snap.GetKV(key)
+}
+
+// Get retrieves the metadata and doc body for the key of doc
+func (s *SafeKVStore) Get(doc *Doc) error {
+	r, err := s.getReader()
+	if err != nil {
+		return err
+	}
+	defer func() { s.readers <- r }()
+	return r.snap.Get(doc)
+}
+
+// SetKV stores the value for key
+func (s *SafeKVStore) SetKV(key, value []byte) error {
+	return s.write(func(k *KVStore) error {
+		return k.SetKV(key, value)
+	})
+}
+
+// Set stores the metadata and body of doc
+func (s *SafeKVStore) Set(doc *Doc) error {
+	return s.write(func(k *KVStore) error {
+		return k.Set(doc)
+	})
+}
+
+// DeleteKV deletes key
+func (s *SafeKVStore) DeleteKV(key []byte) error {
+	return s.write(func(k *KVStore) error {
+		return k.DeleteKV(key)
+	})
+}
+
+// Delete deletes the key of doc
+func (s *SafeKVStore) Delete(doc *Doc) error {
+	return s.write(func(k *KVStore) error {
+		return k.Delete(doc)
+	})
+}
+
+// ExecuteBatch applies the batch through the writer handle
+func (s *SafeKVStore) ExecuteBatch(b *KVBatch, opt CommitOpt) error {
+	return s.write(func(k *KVStore) error {
+		return k.ExecuteBatch(b, opt)
+	})
+}
+
+// Commit commits all pending writes
+func (s *SafeKVStore) Commit(opt CommitOpt) error {
+	return s.write(func(k *KVStore) error {
+		return k.File().Commit(opt)
+	})
+}
+
+// SafeIterator is an Iterator over a private snapshot of a SafeKVStore.
+// A SafeIterator itself must only be used by one goroutine at a time.
+type SafeIterator struct {
+	*Iterator
+	snap *KVStore
+}
+
+// Close the iterator and the snapshot it was reading from
+func (i *SafeIterator) Close() error {
+	err := i.Iterator.Close()
+	err2 := i.snap.Close()
+	if err != nil {
+		return err
+	}
+	return err2
+}
+
+// IteratorInit creates an iterator over a snapshot of the current state
+func (s *SafeKVStore) IteratorInit(startKey, endKey []byte, opt IteratorOpt) (*SafeIterator, error) {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+	if s.closed {
+		return nil, SafeKVStoreClosed
+	}
+
+	snap, _, _, err := s.clone(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := snap.IteratorInit(startKey, endKey, opt)
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	return &SafeIterator{Iterator: iter, snap: snap}, nil
+}
+
+// Close waits for in-flight reads to finish, then closes all
+// snapshot handles, the writer KVStore and its File.
+// Iterators obtained from the store must be closed separately.
+func (s *SafeKVStore) Close() (rverr error) {
+	s.closedMutex.Lock()
+	if s.closed {
+		s.closedMutex.Unlock()
+		return SafeKVStoreClosed
+	}
+	s.closed = true
+	s.closedMutex.Unlock()
+
+	for i := 0; i < cap(s.readers); i++ {
+		r := <-s.readers
+		if r.snap != nil {
+			err := r.snap.Close()
+			if err != nil && rverr == nil {
+				rverr = err
+			}
+		}
+	}
+
+	if s.base != nil {
+		err := s.base.Close()
+		if err != nil && rverr == nil {
+			rverr = err
+		}
+	}
+
+	err := CloseFileKVStore(s.writer)
+	if err != nil && rverr == nil {
+		rverr = err
+	}
+	return
+}
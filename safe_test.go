@@ -0,0 +1,164 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSafeKVStore(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	store, err := NewSafeKVStore("test", nil, "default", nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := []byte(fmt.Sprintf("w%d-%03d", w, i))
+				err := store.SetKV(key, key)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// a completed write must be visible to the next read
+				val, err := store.GetKV(key)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if string(val) != string(key) {
+					t.Errorf("expected %s, got %s", key, val)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	err = store.DeleteKV([]byte("w0-000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.GetKV([]byte("w0-000"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+
+	err = store.Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iter, err := store.IteratorInit(nil, nil, ITR_NO_DELETES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writes after the iterator was created are not visible to it
+	err = store.SetKV([]byte("zzz"), []byte("late"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for {
+		doc, err := iter.Get()
+		if err != nil {
+			break
+		}
+		doc.Close()
+		count++
+		if iter.Next() != nil {
+			break
+		}
+	}
+	err = iter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 399 {
+		t.Errorf("expected to iterate 399, saw %d", count)
+	}
+
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.GetKV([]byte("w1-000"))
+	if err != SafeKVStoreClosed {
+		t.Errorf("expected %v, got %v", SafeKVStoreClosed, err)
+	}
+	err = store.SetKV([]byte("w1-000"), nil)
+	if err != SafeKVStoreClosed {
+		t.Errorf("expected %v, got %v", SafeKVStoreClosed, err)
+	}
+}
+
+func TestSafeKVStoreReadDuringWrite(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	store, err := NewSafeKVStore("test", nil, "default", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	err = store.SetKV([]byte("a"), []byte("val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.GetKV([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := store.baseGen
+
+	// a failed write leaves the published snapshot alone
+	err = store.SetKV(nil, []byte("val"))
+	if err == nil {
+		t.Fatal("expected error setting an empty key")
+	}
+	_, err = store.GetKV([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.baseGen != gen {
+		t.Errorf("expected snapshot generation %d, got %d", gen, store.baseGen)
+	}
+
+	// a write in progress holds the writer lock; reads with an up to
+	// date snapshot must not wait for it
+	store.writeMutex.Lock()
+	done := make(chan error, 1)
+	go func() {
+		val, err := store.GetKV([]byte("a"))
+		if err == nil && string(val) != "val" {
+			err = fmt.Errorf("expected val, got %s", val)
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		err = fmt.Errorf("read blocked behind the writer")
+	}
+	store.writeMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
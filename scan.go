@@ -0,0 +1,209 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// PrefixSuccessor returns the smallest key greater than every key
+// starting with prefix, or nil if there is none (the prefix is empty or
+// consists only of 0xFF bytes).
+func PrefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			rv := make([]byte, i+1)
+			copy(rv, prefix)
+			rv[i]++
+			return rv
+		}
+	}
+	return nil
+}
+
+// KeySuccessor returns the smallest key greater than key
+func KeySuccessor(key []byte) []byte {
+	rv := make([]byte, len(key)+1)
+	copy(rv, key)
+	return rv
+}
+
+// PrefixRange returns the start key, end key and iterator options
+// selecting exactly the keys starting with prefix.  Since end keys are
+// inclusive, the successor of the prefix is excluded with
+// FDB_ITR_SKIP_MAX_KEY.
+func PrefixRange(prefix []byte) (startKey, endKey []byte, opt IteratorOpt) {
+	endKey = PrefixSuccessor(prefix)
+	if endKey != nil {
+		opt = FDB_ITR_SKIP_MAX_KEY
+	}
+	if len(prefix) > 0 {
+		startKey = prefix
+	}
+	return
+}
+
+// PrefixScan creates an iterator over the keys starting with prefix.
+// opt is combined with the options computed by PrefixRange.
+func (k *KVStore) PrefixScan(prefix []byte, opt IteratorOpt) (*Iterator, error) {
+	startKey, endKey, prefixOpt := PrefixRange(prefix)
+	return k.IteratorInit(startKey, endKey, opt|prefixOpt)
+}
+
+// ReverseIterator walks a key range from the largest key down.
+// Next moves towards smaller keys and Prev towards larger keys.
+type ReverseIterator struct {
+	*Iterator
+	empty bool
+}
+
+// ReverseRange creates an iterator over the keys between startKey and
+// endKey, both inclusive unless excluded by FDB_ITR_SKIP_MIN_KEY or
+// FDB_ITR_SKIP_MAX_KEY, positioned at the largest key.
+func (k *KVStore) ReverseRange(startKey, endKey []byte, opt IteratorOpt) (*ReverseIterator, error) {
+	iter, err := k.IteratorInit(startKey, endKey, opt)
+	if err != nil {
+		return nil, err
+	}
+	rv := ReverseIterator{Iterator: iter}
+	err = iter.SeekMax()
+	if err == RESULT_ITERATOR_FAIL {
+		rv.empty = true
+	} else if err != nil {
+		iter.Close()
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// Get gets the current item (key, metadata, doc body) from the iterator
+func (i *ReverseIterator) Get() (*Doc, error) {
+	if i.empty {
+		return nil, RESULT_ITERATOR_FAIL
+	}
+	return i.Iterator.Get()
+}
+
+// GetMetaOnly gets the current item (key, metadata, offset to doc body) from the iterator
+func (i *ReverseIterator) GetMetaOnly() (*Doc, error) {
+	if i.empty {
+		return nil, RESULT_ITERATOR_FAIL
+	}
+	return i.Iterator.GetMetaOnly()
+}
+
+// Next moves the iterator to the next smaller key
+func (i *ReverseIterator) Next() error {
+	if i.empty {
+		return RESULT_ITERATOR_FAIL
+	}
+	return i.Iterator.Prev()
+}
+
+// Prev moves the iterator to the next larger key
+func (i *ReverseIterator) Prev() error {
+	if i.empty {
+		return RESULT_ITERATOR_FAIL
+	}
+	return i.Iterator.Next()
+}
+
+// ScanOpts controls a paginated scan performed by ScanPage
+type ScanOpts struct {
+	// Iterator options, e.g. ITR_NO_DELETES or FDB_ITR_SKIP_MIN_KEY
+	Opt IteratorOpt
+	// Walk the range from the largest key down
+	Reverse bool
+	// Number of docs to skip before the first doc returned
+	Offset int
+	// Maximum number of docs to return, 0 for no limit
+	Limit int
+	// The Resume key of the previous page, to continue after it
+	Resume []byte
+}
+
+// ScanPage is one page of results of ScanPage
+type ScanPage struct {
+	Docs []*Doc
+	// The key to pass as ScanOpts.Resume to fetch the next page,
+	// or nil if the range has been exhausted
+	Resume []byte
+}
+
+// Close releases the docs of the page
+func (p *ScanPage) Close() {
+	for _, doc := range p.Docs {
+		doc.Close()
+	}
+	p.Docs = nil
+}
+
+// isIterEnd returns whether err only signals that an iterator has run
+// out of docs, or that a seek went past the end of its range
+func isIterEnd(err error) bool {
+	return err == RESULT_ITERATOR_FAIL || err == RESULT_SEEK_FAIL || err == RESULT_KEY_NOT_FOUND
+}
+
+type scanIterator interface {
+	Get() (*Doc, error)
+	GetMetaOnly() (*Doc, error)
+	Next() error
+	Close() error
+}
+
+// ScanPage returns up to opts.Limit docs of the range between startKey
+// and endKey, in the direction given by opts.Reverse, after skipping
+// opts.Offset docs.  A page resumed from a previous page's Resume key
+// starts strictly after that key, so concurrent inserts and deletes do
+// not shift page boundaries the way offsets alone would.
+func (k *KVStore) ScanPage(startKey, endKey []byte, opts ScanOpts) (*ScanPage, error) {
+	opt := opts.Opt
+	if opts.Resume != nil {
+		if opts.Reverse {
+			endKey = opts.Resume
+			opt |= FDB_ITR_SKIP_MAX_KEY
+		} else {
+			startKey = opts.Resume
+			opt |= FDB_ITR_SKIP_MIN_KEY
+		}
+	}
+
+	var iter scanIterator
+	var err error
+	if opts.Reverse {
+		iter, err = k.ReverseRange(startKey, endKey, opt)
+	} else {
+		iter, err = k.IteratorInit(startKey, endKey, opt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	rv := &ScanPage{}
+	for i := 0; i < opts.Offset; i++ {
+		if iter.Next() != nil {
+			return rv, nil
+		}
+	}
+	for {
+		doc, err := iter.Get()
+		if isIterEnd(err) {
+			return rv, nil
+		} else if err != nil {
+			rv.Close()
+			return nil, err
+		}
+		rv.Docs = append(rv.Docs, doc)
+		if iter.Next() != nil {
+			return rv, nil
+		}
+		if opts.Limit > 0 && len(rv.Docs) >= opts.Limit {
+			rv.Resume = doc.Key()
+			return rv, nil
+		}
+	}
+}
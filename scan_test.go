@@ -0,0 +1,171 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixSuccessor(t *testing.T) {
+	tests := []struct {
+		in, out []byte
+	}{
+		{nil, nil},
+		{[]byte{0xFF, 0xFF}, nil},
+		{[]byte("abc"), []byte("abd")},
+		{[]byte{'a', 0xFF}, []byte("b")},
+		{[]byte{'a', 0xFE, 0xFF, 0xFF}, []byte{'a', 0xFF}},
+	}
+	for _, test := range tests {
+		out := PrefixSuccessor(test.in)
+		if !bytes.Equal(out, test.out) || (out == nil) != (test.out == nil) {
+			t.Errorf("expected successor of %v to be %v, got %v", test.in, test.out, out)
+		}
+	}
+}
+
+type keyIterator interface {
+	Get() (*Doc, error)
+	Next() error
+}
+
+func iterKeys(iter keyIterator) []string {
+	var rv []string
+	for {
+		doc, err := iter.Get()
+		if err != nil {
+			return rv
+		}
+		rv = append(rv, string(doc.Key()))
+		doc.Close()
+		if iter.Next() != nil {
+			return rv
+		}
+	}
+}
+
+func TestPrefixScanReverseRange(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for _, key := range []string{"a", "a\xff", "a\xff\xff", "ab", "b", "b\x00", "c"} {
+		err = kvstore.SetKV([]byte(key), []byte("val"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iter, err := kvstore.PrefixScan([]byte("a"), ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := iterKeys(iter)
+	iter.Close()
+	if !reflect.DeepEqual(keys, []string{"a", "a\xff", "a\xff\xff", "ab"}) {
+		t.Errorf("unexpected prefix scan %q", keys)
+	}
+
+	iter, err = kvstore.PrefixScan([]byte("a\xff"), ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = iterKeys(iter)
+	iter.Close()
+	if !reflect.DeepEqual(keys, []string{"a\xff", "a\xff\xff"}) {
+		t.Errorf("unexpected prefix scan %q", keys)
+	}
+
+	iter, err = kvstore.PrefixScan([]byte("x"), ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = iterKeys(iter)
+	iter.Close()
+	if len(keys) != 0 {
+		t.Errorf("expected empty prefix scan, got %q", keys)
+	}
+
+	riter, err := kvstore.ReverseRange([]byte("ab"), []byte("c"), FDB_ITR_SKIP_MAX_KEY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = iterKeys(riter)
+	riter.Close()
+	if !reflect.DeepEqual(keys, []string{"b\x00", "b", "ab"}) {
+		t.Errorf("unexpected reverse range %q", keys)
+	}
+}
+
+func TestScanPage(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		err = kvstore.SetKV([]byte(key), []byte("val"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pageKeys := func(p *ScanPage) []string {
+		var rv []string
+		for _, doc := range p.Docs {
+			rv = append(rv, string(doc.Key()))
+		}
+		return rv
+	}
+
+	for _, reverse := range []bool{false, true} {
+		opts := ScanOpts{Opt: ITR_NO_DELETES, Reverse: reverse, Offset: 1, Limit: 2}
+		// a key sorting before the resume key in scan order
+		passed := []byte("b1")
+		if reverse {
+			passed = []byte("f1")
+		}
+		var all []string
+		for {
+			page, err := kvstore.ScanPage([]byte("b"), []byte("g"), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, pageKeys(page)...)
+			page.Close()
+			if page.Resume == nil {
+				break
+			}
+			// a concurrent insert before the resume key must not shift pages
+			kvstore.SetKV(passed, []byte("val"))
+			opts.Resume = page.Resume
+			opts.Offset = 0
+		}
+		kvstore.DeleteKV(passed)
+
+		expected := []string{"c", "d", "e", "f", "g"}
+		if reverse {
+			expected = []string{"f", "e", "d", "c", "b"}
+		}
+		if !reflect.DeepEqual(all, expected) {
+			t.Errorf("reverse %v: expected %q, got %q", reverse, expected, all)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// ShardFunc maps a key to one of n shards
+type ShardFunc func(key []byte, n int) int
+
+// HashShardFunc spreads keys over the shards by their FNV-1a hash
+func HashShardFunc(key []byte, n int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// RangeShardFunc returns a ShardFunc assigning keys by range.  splits
+// must be sorted; keys below splits[0] go to shard 0, keys in
+// [splits[i-1], splits[i]) go to shard i, and keys at or above the last
+// split go to shard len(splits).  The store must have len(splits)+1 shards.
+func RangeShardFunc(splits [][]byte) ShardFunc {
+	return func(key []byte, n int) int {
+		return sort.Search(len(splits), func(i int) bool {
+			return bytes.Compare(key, splits[i]) < 0
+		})
+	}
+}
+
+// ShardedStore spreads a key space over KVStores in several files.
+// Operations on different shards use different File handles, so
+// commits and compactions run on all shards in parallel.  Like KVStore,
+// a ShardedStore must only be used by one goroutine at a time.
+type ShardedStore struct {
+	shards    []*KVStore
+	shardFunc ShardFunc
+}
+
+// OpenShardedStore opens the named KVStore in each of the files, which
+// become the shards in the given order.  If shardFunc is nil
+// HashShardFunc is used.
+func OpenShardedStore(filenames []string, config *Config, kvstore string, kvconfig *KVStoreConfig, shardFunc ShardFunc) (*ShardedStore, error) {
+	if len(filenames) == 0 {
+		return nil, RESULT_INVALID_ARGS
+	}
+	if shardFunc == nil {
+		shardFunc = HashShardFunc
+	}
+	rv := ShardedStore{
+		shards:    make([]*KVStore, 0, len(filenames)),
+		shardFunc: shardFunc,
+	}
+	for _, filename := range filenames {
+		kvs, err := OpenFileKVStore(filename, config, kvstore, kvconfig)
+		if err != nil {
+			// close everything else we've already opened
+			rv.Close()
+			return nil, err
+		}
+		rv.shards = append(rv.shards, kvs)
+	}
+	return &rv, nil
+}
+
+// Shards returns the KVStores backing the store
+func (s *ShardedStore) Shards() []*KVStore {
+	return s.shards
+}
+
+// Shard returns the KVStore responsible for key
+func (s *ShardedStore) Shard(key []byte) *KVStore {
+	return s.shards[s.shardFunc(key, len(s.shards))]
+}
+
+// GetKV returns the value for key from its shard
+func (s *ShardedStore) GetKV(key []byte) ([]byte, error) {
+	return s.Shard(key).GetKV(key)
+}
+
+// SetKV stores the value for key in its shard
+func (s *ShardedStore) SetKV(key, value []byte) error {
+	return s.Shard(key).SetKV(key, value)
+}
+
+// DeleteKV deletes key from its shard
+func (s *ShardedStore) DeleteKV(key []byte) error {
+	return s.Shard(key).DeleteKV(key)
+}
+
+// Get retrieves the metadata and doc body for the key of doc
+func (s *ShardedStore) Get(doc *Doc) error {
+	return s.Shard(doc.Key()).Get(doc)
+}
+
+// Set stores the metadata and body of doc
+func (s *ShardedStore) Set(doc *Doc) error {
+	return s.Shard(doc.Key()).Set(doc)
+}
+
+// Delete deletes the key of doc
+func (s *ShardedStore) Delete(doc *Doc) error {
+	return s.Shard(doc.Key()).Delete(doc)
+}
+
+// each runs f on every shard in parallel and returns the first error
+func (s *ShardedStore) each(f func(i int, kvs *KVStore) error) error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, kvs := range s.shards {
+		wg.Add(1)
+		go func(i int, kvs *KVStore) {
+			defer wg.Done()
+			errs[i] = f(i, kvs)
+		}(i, kvs)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExecuteBatch splits the batch by shard and executes the parts in
+// parallel.  Each part is atomic on its own shard, but the batch as a
+// whole is not.  Ops targeting an explicit KVStore (SetIn, DeleteIn)
+// are not supported.
+func (s *ShardedStore) ExecuteBatch(b *KVBatch, opt CommitOpt) error {
+	if b.err != nil {
+		return b.err
+	}
+	parts := make([]*KVBatch, len(s.shards))
+	for i := range parts {
+		// the parts share the ops, and C memory, of b and must not be Reset
+		parts[i] = &KVBatch{mode: b.mode}
+	}
+	for _, op := range b.ops {
+		if op.kvs != nil {
+			return RESULT_INVALID_ARGS
+		}
+		key := goBytesNoCopy(op.k, op.klen)
+		n := s.shardFunc(key, len(s.shards))
+		parts[n].ops = append(parts[n].ops, op)
+	}
+	return s.each(func(i int, kvs *KVStore) error {
+		if len(parts[i].ops) == 0 {
+			return nil
+		}
+		return kvs.ExecuteBatch(parts[i], opt)
+	})
+}
+
+// Commit commits all shards in parallel
+func (s *ShardedStore) Commit(opt CommitOpt) error {
+	return s.each(func(i int, kvs *KVStore) error {
+		return kvs.File().Commit(opt)
+	})
+}
+
+// Compact compacts all shards in parallel, shard i into newfilenames[i]
+func (s *ShardedStore) Compact(newfilenames []string) error {
+	if len(newfilenames) != len(s.shards) {
+		return RESULT_INVALID_ARGS
+	}
+	return s.each(func(i int, kvs *KVStore) error {
+		return kvs.File().Compact(newfilenames[i])
+	})
+}
+
+// IteratorInit creates an iterator over the key range of all shards,
+// returning keys in order
+func (s *ShardedStore) IteratorInit(startKey, endKey []byte, opt IteratorOpt) (*MergeIterator, error) {
+	iters := make([]*Iterator, 0, len(s.shards))
+	for _, kvs := range s.shards {
+		iter, err := kvs.IteratorInit(startKey, endKey, opt)
+		if err != nil {
+			for _, iter := range iters {
+				iter.Close()
+			}
+			return nil, err
+		}
+		iters = append(iters, iter)
+	}
+	// shards never share keys, so conflicts cannot arise
+	rv := NewMergeIterator(iters, MERGE_FIRST_WINS, false)
+	if rv.err != nil {
+		err := rv.err
+		rv.Close()
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Close closes all shards and their files
+func (s *ShardedStore) Close() (rverr error) {
+	for _, kvs := range s.shards {
+		err := CloseFileKVStore(kvs)
+		if err != nil && rverr == nil {
+			rverr = err
+		}
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestShardedStore(t *testing.T) {
+	files := []string{"test0", "test1", "test2"}
+	for _, f := range files {
+		defer os.RemoveAll(f)
+		defer os.RemoveAll(f + "-compacted")
+	}
+
+	store, err := OpenShardedStore(files, nil, "default", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		err = store.SetKV(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batch := NewKVBatch()
+	defer batch.Reset()
+	for i := 30; i < 40; i++ {
+		batch.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("batched"))
+	}
+	batch.Delete([]byte("key00"))
+	err = store.ExecuteBatch(batch, COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Commit(COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every shard received some keys
+	for i, kvs := range store.Shards() {
+		info, err := kvs.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.DocCount() == 0 {
+			t.Errorf("expected shard %d to hold some docs", i)
+		}
+	}
+
+	val, err := store.GetKV([]byte("key35"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "batched" {
+		t.Errorf("expected batched, got %s", val)
+	}
+	_, err = store.GetKV([]byte("key00"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+
+	iter, err := store.IteratorInit([]byte("key05"), []byte("key25"), ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	expected := 5
+	for {
+		doc, err := iter.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(doc.Key()) != fmt.Sprintf("key%02d", expected) {
+			t.Errorf("expected key%02d, got %s", expected, doc.Key())
+		}
+		doc.Close()
+		expected++
+		if iter.Next() != nil {
+			break
+		}
+	}
+	if expected != 26 {
+		t.Errorf("expected to iterate up to key25, stopped before key%02d", expected)
+	}
+
+	compacted := make([]string, len(files))
+	for i, f := range files {
+		compacted[i] = f + "-compacted"
+	}
+	err = store.Compact(compacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRangeShardFunc(t *testing.T) {
+	fn := RangeShardFunc([][]byte{[]byte("g"), []byte("p")})
+	tests := map[string]int{
+		"a": 0,
+		"f": 0,
+		"g": 1,
+		"o": 1,
+		"p": 2,
+		"z": 2,
+	}
+	for key, shard := range tests {
+		if got := fn([]byte(key), 3); got != shard {
+			t.Errorf("expected %s in shard %d, got %d", key, shard, got)
+		}
+	}
+}
@@ -0,0 +1,564 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+type version struct {
+	seq     SeqNum
+	meta    []byte
+	body    []byte
+	deleted bool
+}
+
+// MemStore is an in-memory Store behaving like a KVStore alone in its
+// file: every write gets the next sequence number, deletes leave
+// tombstones, iterators work on a snapshot taken when they are created,
+// snapshots and rollbacks are limited to committed sequence numbers,
+// and errors use the same codes as ForestDB.  Nothing is persisted.
+// Unlike a KVStore, a MemStore may be used from many goroutines at once.
+type MemStore struct {
+	mutex sync.Mutex
+	// the versions of each key in ascending sequence order
+	history  map[string][]version
+	lastSeq  SeqNum
+	commits  []SeqNum
+	inTxn    bool
+	txnStart SeqNum
+	readOnly bool
+	closed   bool
+}
+
+// NewMemStore creates an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{
+		history: make(map[string][]version),
+	}
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	rv := make([]byte, len(b))
+	copy(rv, b)
+	return rv
+}
+
+// check returns the error for using a closed store.
+// The caller must hold mutex.
+func (m *MemStore) check() error {
+	if m.closed {
+		return RESULT_INVALID_HANDLE
+	}
+	return nil
+}
+
+// checkWrite additionally rejects writes to snapshots.
+// The caller must hold mutex.
+func (m *MemStore) checkWrite() error {
+	err := m.check()
+	if err != nil {
+		return err
+	}
+	if m.readOnly {
+		return RESULT_RONLY_VIOLATION
+	}
+	return nil
+}
+
+func (m *MemStore) latest(key []byte) (version, bool) {
+	h := m.history[string(key)]
+	if len(h) == 0 {
+		return version{}, false
+	}
+	return h[len(h)-1], true
+}
+
+func (m *MemStore) write(key, meta, body []byte, deleted bool) SeqNum {
+	m.lastSeq++
+	m.history[string(key)] = append(m.history[string(key)], version{
+		seq:     m.lastSeq,
+		meta:    clone(meta),
+		body:    clone(body),
+		deleted: deleted,
+	})
+	return m.lastSeq
+}
+
+// truncate drops all versions written after sn.
+// The caller must hold mutex.
+func (m *MemStore) truncate(sn SeqNum) {
+	for key, h := range m.history {
+		n := sort.Search(len(h), func(i int) bool { return h[i].seq > sn })
+		if n == 0 {
+			delete(m.history, key)
+		} else {
+			m.history[key] = h[:n]
+		}
+	}
+	m.lastSeq = sn
+}
+
+// commit records the current state as a commit point.
+// The caller must hold mutex.
+func (m *MemStore) commit() {
+	if len(m.commits) == 0 || m.commits[len(m.commits)-1] != m.lastSeq {
+		m.commits = append(m.commits, m.lastSeq)
+	}
+}
+
+func (m *MemStore) committed(sn SeqNum) bool {
+	i := sort.Search(len(m.commits), func(i int) bool { return m.commits[i] >= sn })
+	return i < len(m.commits) && m.commits[i] == sn
+}
+
+func (m *MemStore) Get(doc *Doc) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return err
+	}
+	if len(doc.Key) == 0 {
+		return RESULT_INVALID_ARGS
+	}
+	v, ok := m.latest(doc.Key)
+	if !ok || v.deleted {
+		return RESULT_KEY_NOT_FOUND
+	}
+	doc.Meta = clone(v.meta)
+	doc.Body = clone(v.body)
+	doc.SeqNum = v.seq
+	doc.Deleted = false
+	return nil
+}
+
+func (m *MemStore) GetMetaOnly(doc *Doc) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return err
+	}
+	if len(doc.Key) == 0 {
+		return RESULT_INVALID_ARGS
+	}
+	v, ok := m.latest(doc.Key)
+	if !ok {
+		return RESULT_KEY_NOT_FOUND
+	}
+	doc.Meta = clone(v.meta)
+	doc.Body = nil
+	doc.SeqNum = v.seq
+	doc.Deleted = v.deleted
+	return nil
+}
+
+func (m *MemStore) GetBySeq(doc *Doc) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return err
+	}
+	for key, h := range m.history {
+		v := h[len(h)-1]
+		if v.seq == doc.SeqNum && !v.deleted {
+			doc.Key = []byte(key)
+			doc.Meta = clone(v.meta)
+			doc.Body = clone(v.body)
+			doc.Deleted = false
+			return nil
+		}
+	}
+	return RESULT_KEY_NOT_FOUND
+}
+
+func (m *MemStore) Set(doc *Doc) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if len(doc.Key) == 0 {
+		return RESULT_INVALID_ARGS
+	}
+	doc.SeqNum = m.write(doc.Key, doc.Meta, doc.Body, false)
+	doc.Deleted = false
+	return nil
+}
+
+func (m *MemStore) Delete(doc *Doc) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if len(doc.Key) == 0 {
+		return RESULT_INVALID_ARGS
+	}
+	doc.SeqNum = m.write(doc.Key, nil, nil, true)
+	doc.Deleted = true
+	return nil
+}
+
+func (m *MemStore) GetKV(key []byte) ([]byte, error) {
+	doc := Doc{Key: key}
+	err := m.Get(&doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Body, nil
+}
+
+func (m *MemStore) SetKV(key, value []byte) error {
+	return m.Set(&Doc{Key: key, Body: value})
+}
+
+func (m *MemStore) DeleteKV(key []byte) error {
+	return m.Delete(&Doc{Key: key})
+}
+
+func (m *MemStore) IteratorInit(startKey, endKey []byte, opt IteratorOpt) (Iterator, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(m.history))
+	for key := range m.history {
+		k := []byte(key)
+		if len(startKey) > 0 {
+			c := bytes.Compare(k, startKey)
+			if c < 0 || (c == 0 && opt&FDB_ITR_SKIP_MIN_KEY != 0) {
+				continue
+			}
+		}
+		if len(endKey) > 0 {
+			c := bytes.Compare(k, endKey)
+			if c > 0 || (c == 0 && opt&FDB_ITR_SKIP_MAX_KEY != 0) {
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return m.iterator(keys, opt, false), nil
+}
+
+func (m *MemStore) IteratorSequenceInit(startSeq, endSeq SeqNum, opt IteratorOpt) (Iterator, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(m.history))
+	for key, h := range m.history {
+		seq := h[len(h)-1].seq
+		if seq >= startSeq && (endSeq == 0 || seq <= endSeq) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		hi, hj := m.history[keys[i]], m.history[keys[j]]
+		return hi[len(hi)-1].seq < hj[len(hj)-1].seq
+	})
+	return m.iterator(keys, opt, true), nil
+}
+
+// iterator captures the latest versions of keys.
+// The caller must hold mutex.
+func (m *MemStore) iterator(keys []string, opt IteratorOpt, bySeq bool) *memIterator {
+	rv := memIterator{bySeq: bySeq}
+	for _, key := range keys {
+		v, _ := m.latest([]byte(key))
+		if v.deleted && opt&ITR_NO_DELETES != 0 {
+			continue
+		}
+		rv.docs = append(rv.docs, &Doc{
+			Key:     []byte(key),
+			Meta:    v.meta,
+			Body:    v.body,
+			SeqNum:  v.seq,
+			Deleted: v.deleted,
+		})
+	}
+	return &rv
+}
+
+func (m *MemStore) NewBatch() Batch {
+	return &memBatch{}
+}
+
+func (m *MemStore) ExecuteBatch(b Batch, opt CommitOpt) error {
+	mb, ok := b.(*memBatch)
+	if !ok {
+		return RESULT_INVALID_ARGS
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if m.inTxn {
+		return RESULT_TRANSACTION_FAIL
+	}
+	for _, op := range mb.ops {
+		if len(op.key) == 0 {
+			return RESULT_INVALID_ARGS
+		}
+	}
+	for _, op := range mb.ops {
+		m.write(op.key, op.meta, op.body, op.del)
+	}
+	m.commit()
+	return nil
+}
+
+func (m *MemStore) BeginTransaction() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if m.inTxn {
+		return RESULT_TRANSACTION_FAIL
+	}
+	m.inTxn = true
+	m.txnStart = m.lastSeq
+	return nil
+}
+
+func (m *MemStore) EndTransaction(opt CommitOpt) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if !m.inTxn {
+		return RESULT_TRANSACTION_FAIL
+	}
+	m.inTxn = false
+	m.commit()
+	return nil
+}
+
+func (m *MemStore) AbortTransaction() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if !m.inTxn {
+		return RESULT_TRANSACTION_FAIL
+	}
+	m.inTxn = false
+	m.truncate(m.txnStart)
+	return nil
+}
+
+func (m *MemStore) Commit(opt CommitOpt) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if m.inTxn {
+		return RESULT_FAIL_BY_TRANSACTION
+	}
+	m.commit()
+	return nil
+}
+
+func (m *MemStore) SnapshotOpen(sn SeqNum) (Store, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	if sn == SnapshotInmem {
+		sn = m.lastSeq
+	} else if !m.committed(sn) {
+		return nil, RESULT_NO_DB_INSTANCE
+	}
+
+	rv := NewMemStore()
+	rv.readOnly = true
+	rv.lastSeq = sn
+	rv.commits = []SeqNum{sn}
+	for key, h := range m.history {
+		n := sort.Search(len(h), func(i int) bool { return h[i].seq > sn })
+		if n > 0 {
+			rv.history[key] = []version{h[n-1]}
+		}
+	}
+	return rv, nil
+}
+
+func (m *MemStore) Rollback(sn SeqNum) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkWrite(); err != nil {
+		return err
+	}
+	if m.inTxn {
+		return RESULT_FAIL_BY_TRANSACTION
+	}
+	if !m.committed(sn) {
+		return RESULT_NO_DB_INSTANCE
+	}
+	m.truncate(sn)
+	for len(m.commits) > 0 && m.commits[len(m.commits)-1] > sn {
+		m.commits = m.commits[:len(m.commits)-1]
+	}
+	return nil
+}
+
+func (m *MemStore) LastSeqNum() (SeqNum, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return 0, err
+	}
+	return m.lastSeq, nil
+}
+
+// Close closes the store, discarding an active transaction
+func (m *MemStore) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.check(); err != nil {
+		return err
+	}
+	if m.inTxn {
+		m.inTxn = false
+		m.truncate(m.txnStart)
+	}
+	m.closed = true
+	return nil
+}
+
+type memIterator struct {
+	docs  []*Doc
+	pos   int
+	bySeq bool
+}
+
+func (i *memIterator) doc(body bool) (*Doc, error) {
+	if i.pos >= len(i.docs) {
+		return nil, RESULT_ITERATOR_FAIL
+	}
+	d := i.docs[i.pos]
+	rv := Doc{
+		Key:     clone(d.Key),
+		Meta:    clone(d.Meta),
+		SeqNum:  d.SeqNum,
+		Deleted: d.Deleted,
+	}
+	if body {
+		rv.Body = clone(d.Body)
+	}
+	return &rv, nil
+}
+
+func (i *memIterator) Get() (*Doc, error) {
+	return i.doc(true)
+}
+
+func (i *memIterator) GetMetaOnly() (*Doc, error) {
+	return i.doc(false)
+}
+
+func (i *memIterator) Next() error {
+	if i.pos+1 >= len(i.docs) {
+		return RESULT_ITERATOR_FAIL
+	}
+	i.pos++
+	return nil
+}
+
+func (i *memIterator) Prev() error {
+	if i.pos == 0 || i.pos >= len(i.docs) {
+		return RESULT_ITERATOR_FAIL
+	}
+	i.pos--
+	return nil
+}
+
+func (i *memIterator) Seek(key []byte, dir SeekOpt) error {
+	if i.bySeq {
+		return RESULT_INVALID_ARGS
+	}
+	n := sort.Search(len(i.docs), func(n int) bool {
+		return bytes.Compare(i.docs[n].Key, key) >= 0
+	})
+	if dir == FDB_ITR_SEEK_LOWER && (n == len(i.docs) || !bytes.Equal(i.docs[n].Key, key)) {
+		n--
+	}
+	if n < 0 || n >= len(i.docs) {
+		return RESULT_ITERATOR_FAIL
+	}
+	i.pos = n
+	return nil
+}
+
+func (i *memIterator) SeekMin() error {
+	if len(i.docs) == 0 {
+		return RESULT_ITERATOR_FAIL
+	}
+	i.pos = 0
+	return nil
+}
+
+func (i *memIterator) SeekMax() error {
+	if len(i.docs) == 0 {
+		return RESULT_ITERATOR_FAIL
+	}
+	i.pos = len(i.docs) - 1
+	return nil
+}
+
+func (i *memIterator) Close() error {
+	i.docs = nil
+	return nil
+}
+
+type memOp struct {
+	key, meta, body []byte
+	del             bool
+}
+
+type memBatch struct {
+	ops []memOp
+}
+
+func (b *memBatch) Set(k, v []byte) {
+	b.SetMeta(k, nil, v)
+}
+
+func (b *memBatch) SetMeta(k, m, v []byte) {
+	b.ops = append(b.ops, memOp{key: clone(k), meta: clone(m), body: clone(v)})
+}
+
+func (b *memBatch) Delete(k []byte) {
+	b.ops = append(b.ops, memOp{key: clone(k), del: true})
+}
+
+func (b *memBatch) Len() int {
+	return len(b.ops)
+}
+
+func (b *memBatch) Reset() {
+	b.ops = nil
+}
@@ -0,0 +1,57 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package store_test
+
+import (
+	"testing"
+
+	"github.com/couchbase/goforestdb/store"
+	"github.com/couchbase/goforestdb/store/storetest"
+)
+
+func TestMemStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (store.Store, func()) {
+		s := store.NewMemStore()
+		return s, func() { s.Close() }
+	})
+}
+
+func TestMemStoreErrors(t *testing.T) {
+	s := store.NewMemStore()
+
+	err := s.SetKV(nil, []byte("val"))
+	if err != store.RESULT_INVALID_ARGS {
+		t.Errorf("expected %v, got %v", store.RESULT_INVALID_ARGS, err)
+	}
+	_, err = s.SnapshotOpen(42)
+	if err != store.RESULT_NO_DB_INSTANCE {
+		t.Errorf("expected %v, got %v", store.RESULT_NO_DB_INSTANCE, err)
+	}
+	err = s.BeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.BeginTransaction()
+	if err != store.RESULT_TRANSACTION_FAIL {
+		t.Errorf("expected %v, got %v", store.RESULT_TRANSACTION_FAIL, err)
+	}
+	err = s.Commit(store.COMMIT_NORMAL)
+	if err != store.RESULT_FAIL_BY_TRANSACTION {
+		t.Errorf("expected %v, got %v", store.RESULT_FAIL_BY_TRANSACTION, err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetKV([]byte("key"))
+	if err != store.RESULT_INVALID_HANDLE {
+		t.Errorf("expected %v, got %v", store.RESULT_INVALID_HANDLE, err)
+	}
+}
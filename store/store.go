@@ -0,0 +1,125 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// Package store defines the Store, Iterator and Batch interfaces
+// capturing the methods of forestdb's KVStore, Iterator and KVBatch, and
+// provides MemStore, a pure Go in-memory implementation which does not
+// need cgo or libforestdb.  Code written against the interfaces can use
+// a real KVStore, through KVStore.AsStore in the forestdb package, in
+// production and a MemStore in unit tests.
+package store
+
+type SeqNum uint64
+
+// SnapshotInmem requests an in-memory snapshot of the current state,
+// including uncommitted writes
+const SnapshotInmem = 1<<64 - 1
+
+// IteratorOpt mirrors forestdb.IteratorOpt
+type IteratorOpt uint16
+
+const (
+	ITR_NONE             IteratorOpt = 0x00
+	ITR_NO_DELETES       IteratorOpt = 0x02
+	FDB_ITR_SKIP_MIN_KEY IteratorOpt = 0x04
+	FDB_ITR_SKIP_MAX_KEY IteratorOpt = 0x08
+)
+
+// SeekOpt mirrors forestdb.SeekOpt
+type SeekOpt uint8
+
+const (
+	FDB_ITR_SEEK_HIGHER SeekOpt = 0x00
+	FDB_ITR_SEEK_LOWER  SeekOpt = 0x01
+)
+
+// CommitOpt mirrors forestdb.CommitOpt
+type CommitOpt uint8
+
+const (
+	COMMIT_NORMAL           CommitOpt = 0x00
+	COMMIT_MANUAL_WAL_FLUSH CommitOpt = 0x01
+)
+
+// Doc is a document read from or written to a Store.  Unlike
+// forestdb.Doc it is plain Go memory and needs no Close.
+type Doc struct {
+	Key     []byte
+	Meta    []byte
+	Body    []byte
+	SeqNum  SeqNum
+	Deleted bool
+}
+
+// Store is a single key-value store within a file, as forestdb.KVStore.
+// Transactions, commits and rollbacks affect the whole file.
+type Store interface {
+	// Get retrieves the metadata and body for doc.Key
+	Get(doc *Doc) error
+	// GetMetaOnly retrieves the metadata for doc.Key, also of a
+	// deleted doc
+	GetMetaOnly(doc *Doc) error
+	// GetBySeq retrieves the doc with sequence number doc.SeqNum
+	GetBySeq(doc *Doc) error
+	// Set stores doc and sets doc.SeqNum to its new sequence number
+	Set(doc *Doc) error
+	// Delete deletes doc.Key and sets doc.SeqNum to the deletion's
+	// sequence number
+	Delete(doc *Doc) error
+
+	GetKV(key []byte) ([]byte, error)
+	SetKV(key, value []byte) error
+	DeleteKV(key []byte) error
+
+	IteratorInit(startKey, endKey []byte, opt IteratorOpt) (Iterator, error)
+	IteratorSequenceInit(startSeq, endSeq SeqNum, opt IteratorOpt) (Iterator, error)
+
+	NewBatch() Batch
+	// ExecuteBatch applies a batch created by NewBatch atomically
+	// and commits
+	ExecuteBatch(b Batch, opt CommitOpt) error
+
+	// BeginTransaction starts a read-committed transaction
+	BeginTransaction() error
+	// EndTransaction commits the transaction
+	EndTransaction(opt CommitOpt) error
+	AbortTransaction() error
+	Commit(opt CommitOpt) error
+
+	// SnapshotOpen opens a read-only snapshot at a committed sequence
+	// number, or of the current state with SnapshotInmem
+	SnapshotOpen(sn SeqNum) (Store, error)
+	// Rollback reverts the store to a committed sequence number
+	Rollback(sn SeqNum) error
+	// LastSeqNum returns the sequence number of the last write
+	LastSeqNum() (SeqNum, error)
+
+	Close() error
+}
+
+// Iterator walks a key or sequence range, as forestdb.Iterator
+type Iterator interface {
+	Get() (*Doc, error)
+	GetMetaOnly() (*Doc, error)
+	Next() error
+	Prev() error
+	Seek(key []byte, dir SeekOpt) error
+	SeekMin() error
+	SeekMax() error
+	Close() error
+}
+
+// Batch queues writes for Store.ExecuteBatch, as forestdb.KVBatch
+type Batch interface {
+	Set(k, v []byte)
+	SetMeta(k, m, v []byte)
+	Delete(k []byte)
+	Len() int
+	Reset()
+}
@@ -0,0 +1,395 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/goforestdb/store"
+)
+
+// Differential interprets program as a sequence of operations, applies
+// each of them to both model and s, and fails t as soon as their results
+// differ.  Any byte string is a valid program, which makes Differential
+// suitable as the body of a fuzz target.  Both stores must start empty.
+//
+// Operations use a small key space so that they collide often, and cover
+// Set, Delete, Commit, batches, transactions, in-memory and committed
+// snapshots, Rollback, and iterators with random bounds, options and
+// Next/Prev/Seek/SeekMin/SeekMax moves.
+func Differential(t *testing.T, program []byte, model, s store.Store) {
+	d := differ{
+		t:       t,
+		p:       program,
+		stores:  [2]store.Store{model, s},
+		commits: [][2]store.SeqNum{{0, 0}},
+		seqs:    true,
+	}
+	for !d.done() {
+		d.step()
+		if t.Failed() {
+			t.Logf("operations applied:\n%s", d.log.String())
+			t.FailNow()
+		}
+	}
+}
+
+const (
+	diffSet = iota
+	diffDelete
+	diffGet
+	diffCommit
+	diffBatch
+	diffTransaction
+	diffIterate
+	diffSnapshot
+	diffRollback
+	diffOps
+)
+
+type differ struct {
+	t      *testing.T
+	p      []byte
+	stores [2]store.Store
+	// committed seqnums of each store, for snapshots and rollbacks
+	commits [][2]store.SeqNum
+	// whether both stores still assign the same seqnums
+	seqs bool
+	n    int
+	log  bytes.Buffer
+}
+
+func (d *differ) done() bool {
+	return len(d.p) == 0
+}
+
+// next consumes a byte of the program, 0 once it is exhausted
+func (d *differ) next() byte {
+	if len(d.p) == 0 {
+		return 0
+	}
+	rv := d.p[0]
+	d.p = d.p[1:]
+	return rv
+}
+
+func (d *differ) logf(format string, args ...interface{}) {
+	fmt.Fprintf(&d.log, format+"\n", args...)
+}
+
+// key picks one of 16 keys, 8 of them prefixes of others
+func (d *differ) key() []byte {
+	b := d.next()
+	key := []byte{'a' + b%8}
+	if b&0x08 != 0 {
+		key = append(key, 'x')
+	}
+	return key
+}
+
+// bound is like key, but may also be empty, which leaves a range open
+func (d *differ) bound() []byte {
+	if d.next()%4 == 0 {
+		return nil
+	}
+	return d.key()
+}
+
+func (d *differ) value() []byte {
+	d.n++
+	return []byte(fmt.Sprintf("val-%d", d.n))
+}
+
+// sameErr compares errors, treating all the ways of reporting the end of
+// an iteration as equivalent
+func sameErr(a, b error) bool {
+	return a == b || (iterEnd(a) && iterEnd(b))
+}
+
+func iterEnd(err error) bool {
+	return err == store.RESULT_ITERATOR_FAIL || err == store.RESULT_SEEK_FAIL ||
+		err == store.RESULT_KEY_NOT_FOUND
+}
+
+func (d *differ) compareErr(what string, errs [2]error) bool {
+	if !sameErr(errs[0], errs[1]) {
+		d.t.Errorf("%s: model returned %v, store returned %v", what, errs[0], errs[1])
+		return false
+	}
+	return errs[0] == nil
+}
+
+func (d *differ) compareDoc(what string, docs [2]*store.Doc) {
+	a, b := docs[0], docs[1]
+	if !bytes.Equal(a.Key, b.Key) || (d.seqs && a.SeqNum != b.SeqNum) || a.Deleted != b.Deleted ||
+		(!a.Deleted && !bytes.Equal(a.Body, b.Body)) {
+		d.t.Errorf("%s: model returned %s, store returned %s", what, formatDoc(a), formatDoc(b))
+	}
+}
+
+func formatDoc(doc *store.Doc) string {
+	return fmt.Sprintf("{key:%q body:%q seq:%d deleted:%v}", doc.Key, doc.Body, doc.SeqNum, doc.Deleted)
+}
+
+// each applies fn to both stores
+func (d *differ) each(fn func(s store.Store) error) (errs [2]error) {
+	for i, s := range d.stores {
+		errs[i] = fn(s)
+	}
+	return
+}
+
+// committed records the current seqnums as a commit point
+func (d *differ) committed() {
+	var sn [2]store.SeqNum
+	for i, s := range d.stores {
+		var err error
+		sn[i], err = s.LastSeqNum()
+		if err != nil {
+			d.t.Fatal(err)
+		}
+	}
+	if d.commits[len(d.commits)-1] != sn {
+		d.commits = append(d.commits, sn)
+	}
+}
+
+func (d *differ) step() {
+	switch d.next() % diffOps {
+	case diffSet:
+		key, value := d.key(), d.value()
+		d.logf("set %s %s", key, value)
+		var docs [2]store.Doc
+		var errs [2]error
+		for i, s := range d.stores {
+			docs[i] = store.Doc{Key: key, Body: value}
+			errs[i] = s.Set(&docs[i])
+		}
+		if d.compareErr("set", errs) && d.seqs && docs[0].SeqNum != docs[1].SeqNum {
+			d.t.Errorf("set: model assigned seqnum %d, store assigned %d", docs[0].SeqNum, docs[1].SeqNum)
+		}
+
+	case diffDelete:
+		key := d.key()
+		d.logf("delete %s", key)
+		d.compareErr("delete", d.each(func(s store.Store) error {
+			return s.DeleteKV(key)
+		}))
+
+	case diffGet:
+		d.get(d.key())
+
+	case diffCommit:
+		d.logf("commit")
+		if d.compareErr("commit", d.each(func(s store.Store) error {
+			return s.Commit(store.COMMIT_NORMAL)
+		})) {
+			d.committed()
+		}
+
+	case diffBatch:
+		n := int(d.next()%4) + 1
+		ops := make([]func(store.Batch), n)
+		for i := range ops {
+			key := d.key()
+			if d.next()%3 == 0 {
+				d.logf("batch delete %s", key)
+				ops[i] = func(b store.Batch) { b.Delete(key) }
+			} else {
+				value := d.value()
+				d.logf("batch set %s %s", key, value)
+				ops[i] = func(b store.Batch) { b.Set(key, value) }
+			}
+		}
+		d.logf("batch execute")
+		if d.compareErr("batch", d.each(func(s store.Store) error {
+			b := s.NewBatch()
+			for _, op := range ops {
+				op(b)
+			}
+			return s.ExecuteBatch(b, store.COMMIT_NORMAL)
+		})) {
+			d.committed()
+		}
+
+	case diffTransaction:
+		d.transaction()
+
+	case diffIterate:
+		d.iterate(d.stores)
+
+	case diffSnapshot:
+		sn := [2]store.SeqNum{store.SnapshotInmem, store.SnapshotInmem}
+		if b := d.next(); b%2 == 0 {
+			sn = d.commits[int(b/2)%len(d.commits)]
+		}
+		if sn[0] == 0 {
+			return
+		}
+		d.logf("snapshot %d", sn[0])
+		var snaps [2]store.Store
+		var errs [2]error
+		for i, s := range d.stores {
+			snaps[i], errs[i] = s.SnapshotOpen(sn[i])
+			if errs[i] == nil {
+				defer snaps[i].Close()
+			}
+		}
+		if d.compareErr("snapshot", errs) {
+			d.iterate(snaps)
+		}
+
+	case diffRollback:
+		// commit first, so that the stores agree on what there is to
+		// roll back
+		d.logf("commit")
+		if !d.compareErr("commit", d.each(func(s store.Store) error {
+			return s.Commit(store.COMMIT_NORMAL)
+		})) {
+			return
+		}
+		d.committed()
+		i := int(d.next()) % len(d.commits)
+		sn := d.commits[i]
+		if sn[0] == 0 || i == len(d.commits)-1 {
+			return
+		}
+		d.logf("rollback %d", sn[0])
+		var errs [2]error
+		for j, s := range d.stores {
+			errs[j] = s.Rollback(sn[j])
+		}
+		if d.compareErr("rollback", errs) {
+			d.commits = d.commits[:i+1]
+		}
+	}
+}
+
+func (d *differ) get(key []byte) {
+	d.logf("get %s", key)
+	var values [2][]byte
+	var errs [2]error
+	for i, s := range d.stores {
+		values[i], errs[i] = s.GetKV(key)
+	}
+	if d.compareErr("get", errs) && !bytes.Equal(values[0], values[1]) {
+		d.t.Errorf("get %s: model returned %q, store returned %q", key, values[0], values[1])
+	}
+}
+
+func (d *differ) transaction() {
+	d.logf("begin")
+	if !d.compareErr("begin", d.each(func(s store.Store) error {
+		return s.BeginTransaction()
+	})) {
+		return
+	}
+	for n := d.next() % 4; n > 0; n-- {
+		key, value := d.key(), d.value()
+		d.logf("set %s %s", key, value)
+		d.compareErr("set", d.each(func(s store.Store) error {
+			return s.SetKV(key, value)
+		}))
+	}
+	// a transaction sees its own writes
+	d.get(d.key())
+
+	if d.next()%2 == 0 {
+		d.logf("abort")
+		d.compareErr("abort", d.each(func(s store.Store) error {
+			return s.AbortTransaction()
+		}))
+		// whether the seqnums of aborted writes are reused is not
+		// specified, so stop comparing them
+		d.seqs = false
+		return
+	}
+	d.logf("end")
+	if d.compareErr("end", d.each(func(s store.Store) error {
+		return s.EndTransaction(store.COMMIT_NORMAL)
+	})) {
+		d.committed()
+	}
+}
+
+// iterate opens an iterator on each of stores and moves them in step
+func (d *differ) iterate(stores [2]store.Store) {
+	start, end := d.bound(), d.bound()
+	opt := store.IteratorOpt(d.next()) &
+		(store.ITR_NO_DELETES | store.FDB_ITR_SKIP_MIN_KEY | store.FDB_ITR_SKIP_MAX_KEY)
+	d.logf("iterate %q %q opt %#x", start, end, opt)
+
+	var iters [2]store.Iterator
+	var errs [2]error
+	for i, s := range stores {
+		iters[i], errs[i] = s.IteratorInit(start, end, opt)
+		if errs[i] == nil {
+			defer iters[i].Close()
+		}
+	}
+	if !d.compareErr("iterator init", errs) {
+		return
+	}
+
+	if !d.compareCurrent(iters) {
+		return
+	}
+	for n := d.next() % 8; n > 0; n-- {
+		var what string
+		var move func(store.Iterator) error
+		switch d.next() % 6 {
+		case 0, 1:
+			what = "next"
+			move = store.Iterator.Next
+		case 2:
+			what = "prev"
+			move = store.Iterator.Prev
+		case 3:
+			what = "seek min"
+			move = store.Iterator.SeekMin
+		case 4:
+			what = "seek max"
+			move = store.Iterator.SeekMax
+		case 5:
+			key := d.key()
+			dir := store.FDB_ITR_SEEK_HIGHER
+			if d.next()%2 == 1 {
+				dir = store.FDB_ITR_SEEK_LOWER
+			}
+			what = fmt.Sprintf("seek %s dir %d", key, dir)
+			move = func(iter store.Iterator) error { return iter.Seek(key, dir) }
+		}
+		d.logf("  %s", what)
+		for i, iter := range iters {
+			errs[i] = move(iter)
+		}
+		// the position after running off the end is unspecified
+		if !d.compareErr(what, errs) || !d.compareCurrent(iters) {
+			return
+		}
+	}
+}
+
+// compareCurrent compares the docs the iterators are positioned at,
+// returning whether both are positioned at a doc
+func (d *differ) compareCurrent(iters [2]store.Iterator) bool {
+	var docs [2]*store.Doc
+	var errs [2]error
+	for i, iter := range iters {
+		docs[i], errs[i] = iter.Get()
+	}
+	if !d.compareErr("iterator get", errs) {
+		return false
+	}
+	d.compareDoc("iterator get", docs)
+	return true
+}
@@ -0,0 +1,342 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// Package storetest checks that a store.Store implementation behaves
+// like ForestDB.  The same suite runs against KVStore.AsStore and
+// store.MemStore, which keeps the fake faithful to the real thing.
+package storetest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/couchbase/goforestdb/store"
+)
+
+// OpenFunc returns a new empty store and a function releasing it
+type OpenFunc func(t *testing.T) (store.Store, func())
+
+// Run runs every check of the suite as a subtest, each on a new store
+func Run(t *testing.T, open OpenFunc) {
+	for _, test := range []struct {
+		name string
+		fn   func(*testing.T, store.Store)
+	}{
+		{"GetSetDelete", testGetSetDelete},
+		{"Iterator", testIterator},
+		{"SequenceIterator", testSequenceIterator},
+		{"Batch", testBatch},
+		{"Transaction", testTransaction},
+		{"Snapshot", testSnapshot},
+		{"Rollback", testRollback},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			s, done := open(t)
+			defer done()
+			test.fn(t, s)
+		})
+	}
+}
+
+func set(t *testing.T, s store.Store, keys ...string) {
+	for _, key := range keys {
+		err := s.SetKV([]byte(key), []byte("val-"+key))
+		if err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+}
+
+func expectErr(t *testing.T, what string, err, expected error) {
+	if err != expected {
+		t.Errorf("%s: expected %v, got %v", what, expected, err)
+	}
+}
+
+// keys returns the keys left to iterate
+func keys(t *testing.T, iter store.Iterator) []string {
+	var rv []string
+	for {
+		doc, err := iter.GetMetaOnly()
+		if err != nil {
+			break
+		}
+		rv = append(rv, string(doc.Key))
+		if iter.Next() != nil {
+			break
+		}
+	}
+	iter.Close()
+	return rv
+}
+
+func expectKeys(t *testing.T, what string, got []string, expected ...string) {
+	if len(got) != len(expected) {
+		t.Errorf("%s: expected %q, got %q", what, expected, got)
+		return
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("%s: expected %q, got %q", what, expected, got)
+			return
+		}
+	}
+}
+
+func testGetSetDelete(t *testing.T, s store.Store) {
+	_, err := s.GetKV([]byte("a"))
+	expectErr(t, "get missing", err, store.RESULT_KEY_NOT_FOUND)
+
+	doc := store.Doc{Key: []byte("a"), Meta: []byte("meta"), Body: []byte("body")}
+	err = s.Set(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := doc.SeqNum
+
+	got := store.Doc{Key: []byte("a")}
+	err = s.Get(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Meta) != "meta" || string(got.Body) != "body" || got.SeqNum != first {
+		t.Errorf("unexpected doc %+v", got)
+	}
+	got = store.Doc{SeqNum: first}
+	err = s.GetBySeq(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Key) != "a" || string(got.Body) != "body" {
+		t.Errorf("unexpected doc by seq %+v", got)
+	}
+
+	err = s.Delete(&store.Doc{Key: []byte("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetKV([]byte("a"))
+	expectErr(t, "get deleted", err, store.RESULT_KEY_NOT_FOUND)
+
+	last, err := s.LastSeqNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != first+1 {
+		t.Errorf("expected last seqnum %d, got %d", first+1, last)
+	}
+}
+
+func testIterator(t *testing.T, s store.Store) {
+	set(t, s, "a", "b", "c", "d", "e")
+	err := s.DeleteKV([]byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iter, err := s.IteratorInit(nil, nil, store.ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, "all", keys(t, iter), "a", "b", "c", "d", "e")
+
+	iter, err = s.IteratorInit([]byte("b"), []byte("e"), store.ITR_NO_DELETES|store.FDB_ITR_SKIP_MIN_KEY|store.FDB_ITR_SKIP_MAX_KEY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, "skip bounds", keys(t, iter), "d")
+
+	// writes after creation are not seen by the iterator
+	iter, err = s.IteratorInit(nil, nil, store.ITR_NO_DELETES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, s, "aa")
+	err = iter.Prev()
+	expectErr(t, "prev at start", err, store.RESULT_ITERATOR_FAIL)
+	err = iter.Seek([]byte("c"), store.FDB_ITR_SEEK_HIGHER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := iter.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc.Key) != "d" || string(doc.Body) != "val-d" {
+		t.Errorf("expected d after seek, got %+v", doc)
+	}
+	err = iter.Seek([]byte("c"), store.FDB_ITR_SEEK_LOWER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iter.Prev()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, "after seek lower", keys(t, iter), "a", "b", "d", "e")
+}
+
+func testSequenceIterator(t *testing.T, s store.Store) {
+	set(t, s, "c", "a", "b")
+	set(t, s, "c")
+
+	iter, err := s.IteratorSequenceInit(0, 0, store.ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, "by seq", keys(t, iter), "a", "b", "c")
+}
+
+func testBatch(t *testing.T, s store.Store) {
+	b := s.NewBatch()
+	b.Set([]byte("a"), []byte("1"))
+	b.SetMeta([]byte("b"), []byte("m"), []byte("2"))
+	b.Delete([]byte("a"))
+	if b.Len() != 3 {
+		t.Errorf("expected 3 ops, got %d", b.Len())
+	}
+	err := s.ExecuteBatch(b, store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+
+	_, err = s.GetKV([]byte("a"))
+	expectErr(t, "get deleted", err, store.RESULT_KEY_NOT_FOUND)
+	doc := store.Doc{Key: []byte("b")}
+	err = s.Get(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc.Meta) != "m" || string(doc.Body) != "2" {
+		t.Errorf("unexpected doc %+v", doc)
+	}
+}
+
+func testTransaction(t *testing.T, s store.Store) {
+	set(t, s, "a")
+	err := s.Commit(store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.BeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, s, "b")
+	// the transaction sees its own writes
+	_, err = s.GetKV([]byte("b"))
+	if err != nil {
+		t.Error(err)
+	}
+	err = s.AbortTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetKV([]byte("b"))
+	expectErr(t, "get aborted", err, store.RESULT_KEY_NOT_FOUND)
+
+	err = s.BeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, s, "c")
+	err = s.EndTransaction(store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetKV([]byte("c"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func testSnapshot(t *testing.T, s store.Store) {
+	set(t, s, "a")
+	err := s.Commit(store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sn, err := s.LastSeqNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, s, "a", "b")
+
+	snap, err := s.SnapshotOpen(sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	iter, err := snap.IteratorInit(nil, nil, store.ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, "snapshot", keys(t, iter), "a")
+	err = snap.SetKV([]byte("c"), []byte("val"))
+	if err == nil {
+		t.Errorf("expected write to snapshot to fail")
+	}
+
+	inmem, err := s.SnapshotOpen(store.SnapshotInmem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inmem.Close()
+	set(t, s, "c")
+	iter, err = inmem.IteratorInit(nil, nil, store.ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, "in-memory snapshot", keys(t, iter), "a", "b")
+}
+
+func testRollback(t *testing.T, s store.Store) {
+	set(t, s, "a")
+	err := s.Commit(store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sn, err := s.LastSeqNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, s, "b")
+	err = s.DeleteKV([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Commit(store.COMMIT_NORMAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Rollback(sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := s.GetKV([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val, []byte("val-a")) {
+		t.Errorf("expected val-a, got %s", val)
+	}
+	_, err = s.GetKV([]byte("b"))
+	expectErr(t, "get rolled back", err, store.RESULT_KEY_NOT_FOUND)
+	last, err := s.LastSeqNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != sn {
+		t.Errorf("expected last seqnum %d, got %d", sn, last)
+	}
+}
@@ -0,0 +1,266 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"github.com/couchbase/goforestdb/store"
+)
+
+// AsStore returns the KVStore as a store.Store, so that code written
+// against the store interfaces can run on ForestDB as well as on the
+// in-memory store.MemStore.  Errors are returned as store.Error with
+// the same codes.
+func (k *KVStore) AsStore() store.Store {
+	return &storeAdapter{k: k}
+}
+
+type storeAdapter struct {
+	k *KVStore
+}
+
+// storeError converts forestdb errors to the store package's type
+func storeError(err error) error {
+	if e, ok := err.(Error); ok {
+		return store.Error(e)
+	}
+	return err
+}
+
+// file returns the File for file level operations, which snapshots lack
+func (a *storeAdapter) file() (*File, error) {
+	if a.k.f == nil {
+		return nil, store.RESULT_RONLY_VIOLATION
+	}
+	return a.k.f, nil
+}
+
+// get reads doc.Key, or doc.SeqNum, with one of the KVStore's getters
+func (a *storeAdapter) get(doc *store.Doc, key []byte, get func(*Doc) error, body bool) error {
+	d, err := NewDoc(key, nil, nil)
+	if err != nil {
+		return storeError(err)
+	}
+	defer d.Close()
+	if key == nil {
+		d.SetSeqNum(SeqNum(doc.SeqNum))
+	}
+	err = get(d)
+	if err != nil {
+		return storeError(err)
+	}
+	doc.Key = d.Key()
+	doc.Meta = d.Meta()
+	doc.Body = nil
+	if body {
+		doc.Body = d.Body()
+	}
+	doc.SeqNum = store.SeqNum(d.SeqNum())
+	doc.Deleted = d.Deleted()
+	return nil
+}
+
+func (a *storeAdapter) Get(doc *store.Doc) error {
+	return a.get(doc, doc.Key, a.k.Get, true)
+}
+
+func (a *storeAdapter) GetMetaOnly(doc *store.Doc) error {
+	return a.get(doc, doc.Key, a.k.GetMetaOnly, false)
+}
+
+func (a *storeAdapter) GetBySeq(doc *store.Doc) error {
+	return a.get(doc, nil, a.k.GetBySeq, true)
+}
+
+// write applies a Set or Delete and records the assigned sequence number
+func (a *storeAdapter) write(doc *store.Doc, meta, body []byte, write func(*Doc) error) error {
+	d, err := NewDoc(doc.Key, meta, body)
+	if err != nil {
+		return storeError(err)
+	}
+	defer d.Close()
+	err = write(d)
+	if err != nil {
+		return storeError(err)
+	}
+	doc.SeqNum = store.SeqNum(d.SeqNum())
+	return nil
+}
+
+func (a *storeAdapter) Set(doc *store.Doc) error {
+	err := a.write(doc, doc.Meta, doc.Body, a.k.Set)
+	if err == nil {
+		doc.Deleted = false
+	}
+	return err
+}
+
+func (a *storeAdapter) Delete(doc *store.Doc) error {
+	err := a.write(doc, nil, nil, a.k.Delete)
+	if err == nil {
+		doc.Deleted = true
+	}
+	return err
+}
+
+func (a *storeAdapter) GetKV(key []byte) ([]byte, error) {
+	rv, err := a.k.GetKV(key)
+	return rv, storeError(err)
+}
+
+func (a *storeAdapter) SetKV(key, value []byte) error {
+	return storeError(a.k.SetKV(key, value))
+}
+
+func (a *storeAdapter) DeleteKV(key []byte) error {
+	return storeError(a.k.DeleteKV(key))
+}
+
+func (a *storeAdapter) IteratorInit(startKey, endKey []byte, opt store.IteratorOpt) (store.Iterator, error) {
+	iter, err := a.k.IteratorInit(startKey, endKey, IteratorOpt(opt))
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &storeIterator{iter: iter}, nil
+}
+
+func (a *storeAdapter) IteratorSequenceInit(startSeq, endSeq store.SeqNum, opt store.IteratorOpt) (store.Iterator, error) {
+	iter, err := a.k.IteratorSequenceInit(SeqNum(startSeq), SeqNum(endSeq), IteratorOpt(opt))
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &storeIterator{iter: iter}, nil
+}
+
+// NewBatch returns a *KVBatch, which implements store.Batch
+func (a *storeAdapter) NewBatch() store.Batch {
+	return NewKVBatch()
+}
+
+func (a *storeAdapter) ExecuteBatch(b store.Batch, opt store.CommitOpt) error {
+	kb, ok := b.(*KVBatch)
+	if !ok {
+		return store.RESULT_INVALID_ARGS
+	}
+	return storeError(a.k.ExecuteBatch(kb, CommitOpt(opt)))
+}
+
+func (a *storeAdapter) BeginTransaction() error {
+	f, err := a.file()
+	if err != nil {
+		return err
+	}
+	return storeError(f.BeginTransaction(ISOLATION_READ_COMMITTED))
+}
+
+func (a *storeAdapter) EndTransaction(opt store.CommitOpt) error {
+	f, err := a.file()
+	if err != nil {
+		return err
+	}
+	return storeError(f.EndTransaction(CommitOpt(opt)))
+}
+
+func (a *storeAdapter) AbortTransaction() error {
+	f, err := a.file()
+	if err != nil {
+		return err
+	}
+	return storeError(f.AbortTransaction())
+}
+
+func (a *storeAdapter) Commit(opt store.CommitOpt) error {
+	f, err := a.file()
+	if err != nil {
+		return err
+	}
+	return storeError(f.Commit(CommitOpt(opt)))
+}
+
+func (a *storeAdapter) SnapshotOpen(sn store.SeqNum) (store.Store, error) {
+	snap, err := a.k.SnapshotOpen(SeqNum(sn))
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &storeAdapter{k: snap}, nil
+}
+
+func (a *storeAdapter) Rollback(sn store.SeqNum) error {
+	return storeError(a.k.Rollback(SeqNum(sn)))
+}
+
+func (a *storeAdapter) LastSeqNum() (store.SeqNum, error) {
+	info, err := a.k.Info()
+	if err != nil {
+		return 0, storeError(err)
+	}
+	return store.SeqNum(info.LastSeqNum()), nil
+}
+
+func (a *storeAdapter) Close() error {
+	return storeError(a.k.Close())
+}
+
+type storeIterator struct {
+	iter *Iterator
+}
+
+func storeDoc(d *Doc, body bool) *store.Doc {
+	rv := store.Doc{
+		Key:     d.Key(),
+		Meta:    d.Meta(),
+		SeqNum:  store.SeqNum(d.SeqNum()),
+		Deleted: d.Deleted(),
+	}
+	if body {
+		rv.Body = d.Body()
+	}
+	d.Close()
+	return &rv
+}
+
+func (i *storeIterator) Get() (*store.Doc, error) {
+	d, err := i.iter.Get()
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return storeDoc(d, true), nil
+}
+
+func (i *storeIterator) GetMetaOnly() (*store.Doc, error) {
+	d, err := i.iter.GetMetaOnly()
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return storeDoc(d, false), nil
+}
+
+func (i *storeIterator) Next() error {
+	return storeError(i.iter.Next())
+}
+
+func (i *storeIterator) Prev() error {
+	return storeError(i.iter.Prev())
+}
+
+func (i *storeIterator) Seek(key []byte, dir store.SeekOpt) error {
+	return storeError(i.iter.Seek(key, SeekOpt(dir)))
+}
+
+func (i *storeIterator) SeekMin() error {
+	return storeError(i.iter.SeekMin())
+}
+
+func (i *storeIterator) SeekMax() error {
+	return storeError(i.iter.SeekMax())
+}
+
+func (i *storeIterator) Close() error {
+	return storeError(i.iter.Close())
+}
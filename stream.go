@@ -0,0 +1,329 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// DefaultStreamChunkSize is the size of the chunk docs PutStream
+// splits values into when not given a chunk size
+const DefaultStreamChunkSize = 256 * 1024
+
+// appended to the name of a KVStore to name the companion KVStore
+// holding the chunks of its streams
+const streamChunkStoreSuffix = ":stream-chunks"
+
+var NotAStream = fmt.Errorf("doc is not a stream manifest")
+var StreamChecksumMismatch = fmt.Errorf("stream chunk checksum mismatch")
+
+// marks the manifest doc of a stream in its metadata
+var streamManifestMeta = []byte("fdb-stream")
+
+const streamManifestVersion = 1
+
+var streamCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// streamManifest describes the chunks of a stream.  It is stored as the
+// body of the doc at the stream's key, and chunk i is stored at
+// streamChunkKey(key, i) in the companion KVStore returned by
+// streamChunks.
+type streamManifest struct {
+	length    uint64
+	chunkSize uint32
+	sums      []uint32
+}
+
+func (m *streamManifest) encode() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(streamManifestVersion)
+	binary.Write(buf, binary.BigEndian, m.length)
+	binary.Write(buf, binary.BigEndian, m.chunkSize)
+	binary.Write(buf, binary.BigEndian, uint32(len(m.sums)))
+	binary.Write(buf, binary.BigEndian, m.sums)
+	return buf.Bytes()
+}
+
+func decodeStreamManifest(b []byte) (*streamManifest, error) {
+	if len(b) < 17 || b[0] != streamManifestVersion {
+		return nil, NotAStream
+	}
+	rv := streamManifest{
+		length:    binary.BigEndian.Uint64(b[1:]),
+		chunkSize: binary.BigEndian.Uint32(b[9:]),
+	}
+	count := binary.BigEndian.Uint32(b[13:])
+	if uint64(len(b)-17) != 4*uint64(count) {
+		return nil, NotAStream
+	}
+	rv.sums = make([]uint32, count)
+	for i := range rv.sums {
+		rv.sums[i] = binary.BigEndian.Uint32(b[17+4*i:])
+	}
+	return &rv, nil
+}
+
+// streamChunkKey returns the key of chunk i of the stream at key.  As
+// the index has a fixed length, the chunk keys of different streams
+// never collide.
+func streamChunkKey(key []byte, i int) []byte {
+	rv := make([]byte, len(key), len(key)+4)
+	copy(rv, key)
+	return append(rv, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+}
+
+// streamChunks opens the companion KVStore holding the chunks of the
+// streams stored in this KVStore, creating it if needed.  Keeping the
+// chunks out of this KVStore hides them from its iterators and counts.
+func (k *KVStore) streamChunks() (*KVStore, error) {
+	if k.f == nil {
+		// a snapshot does not know its File
+		return nil, RESULT_INVALID_HANDLE
+	}
+	return k.f.OpenKVStore(k.name+streamChunkStoreSuffix, k.config)
+}
+
+// streamManifest returns the manifest of the stream at key, or nil if
+// there is no doc at key
+func (k *KVStore) streamManifest(key []byte) (*streamManifest, error) {
+	doc, err := NewDoc(key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+
+	err = k.Get(doc)
+	if err == RESULT_KEY_NOT_FOUND {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(doc.Meta(), streamManifestMeta) {
+		return nil, NotAStream
+	}
+	return decodeStreamManifest(doc.Body())
+}
+
+// streamTransaction runs f inside a transaction on the KVStore's File
+func (k *KVStore) streamTransaction(f func() error) (err error) {
+	err = k.File().BeginTransaction(ISOLATION_READ_COMMITTED)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err == nil {
+			err = k.File().EndTransaction(COMMIT_NORMAL)
+		} else {
+			_ = k.File().AbortTransaction()
+		}
+	}()
+	return f()
+}
+
+// PutStream stores everything read from r at key, split into chunk docs
+// of chunkSize bytes (DefaultStreamChunkSize if chunkSize < 1).  The
+// manifest at key and all chunks are written in one transaction,
+// replacing any previous stream at key.
+//
+// Only the manifest is stored in this KVStore.  The chunks are stored in
+// a companion KVStore, named after this one with ":stream-chunks"
+// appended, at keys formed by appending a 4 byte index to key.  Streams
+// must be removed with DeleteStream, as deleting or overwriting the
+// manifest by other means leaves the chunks behind.
+func (k *KVStore) PutStream(key []byte, r io.Reader, chunkSize int) error {
+	if chunkSize < 1 {
+		chunkSize = DefaultStreamChunkSize
+	}
+
+	old, err := k.streamManifest(key)
+	if err == NotAStream {
+		// a plain doc is simply replaced
+		old = nil
+	} else if err != nil {
+		return err
+	}
+
+	chunks, err := k.streamChunks()
+	if err != nil {
+		return err
+	}
+	defer chunks.Close()
+
+	return k.streamTransaction(func() error {
+		m := streamManifest{chunkSize: uint32(chunkSize)}
+		buf := make([]byte, m.chunkSize)
+		for {
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				err := chunks.SetKV(streamChunkKey(key, len(m.sums)), buf[:n])
+				if err != nil {
+					return err
+				}
+				m.sums = append(m.sums, crc32.Checksum(buf[:n], streamCRCTable))
+				m.length += uint64(n)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		if old != nil {
+			for i := len(m.sums); i < len(old.sums); i++ {
+				err := chunks.DeleteKV(streamChunkKey(key, i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		doc, err := NewDoc(key, streamManifestMeta, m.encode())
+		if err != nil {
+			return err
+		}
+		defer doc.Close()
+		return k.Set(doc)
+	})
+}
+
+// DeleteStream deletes the manifest and all chunks of the stream at key
+// in one transaction
+func (k *KVStore) DeleteStream(key []byte) error {
+	m, err := k.streamManifest(key)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return RESULT_KEY_NOT_FOUND
+	}
+
+	chunks, err := k.streamChunks()
+	if err != nil {
+		return err
+	}
+	defer chunks.Close()
+
+	return k.streamTransaction(func() error {
+		for i := range m.sums {
+			err := chunks.DeleteKV(streamChunkKey(key, i))
+			if err != nil {
+				return err
+			}
+		}
+		return k.DeleteKV(key)
+	})
+}
+
+// GetStream returns a reader over the stream stored at key by PutStream.
+// The reader works on a snapshot, so it is unaffected by later writes,
+// and verifies the checksum of every chunk, failing with
+// StreamChecksumMismatch if one is corrupt.  It must be closed.
+func (k *KVStore) GetStream(key []byte) (io.ReadCloser, error) {
+	snap, chunks, err := k.streamSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	m, err := snap.streamManifest(key)
+	if err == nil && m == nil {
+		err = RESULT_KEY_NOT_FOUND
+	}
+	if err != nil {
+		snap.Close()
+		chunks.Close()
+		return nil, err
+	}
+	return &streamReader{snap: snap, chunks: chunks, key: key, manifest: m}, nil
+}
+
+// streamSnapshots opens snapshots of this KVStore and of its chunks that
+// agree with each other.  Streams are only written in transactions that
+// also write this KVStore, so if its seqnum did not move while the
+// chunks were being snapshotted, no stream changed in between.
+func (k *KVStore) streamSnapshots() (snap, chunks *KVStore, err error) {
+	store, err := k.streamChunks()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer store.Close()
+
+	for {
+		snap, err = k.SnapshotOpen(SnapshotInmem)
+		if err != nil {
+			return nil, nil, err
+		}
+		chunks, err = store.SnapshotOpen(SnapshotInmem)
+		if err != nil {
+			snap.Close()
+			return nil, nil, err
+		}
+
+		var before, after *KVStoreInfo
+		before, err = snap.Info()
+		if err == nil {
+			after, err = k.Info()
+		}
+		if err != nil {
+			snap.Close()
+			chunks.Close()
+			return nil, nil, err
+		}
+		if before.LastSeqNum() == after.LastSeqNum() {
+			return snap, chunks, nil
+		}
+		snap.Close()
+		chunks.Close()
+	}
+}
+
+type streamReader struct {
+	snap     *KVStore
+	chunks   *KVStore
+	key      []byte
+	manifest *streamManifest
+	next     int
+	read     uint64
+	buf      []byte
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next >= len(r.manifest.sums) {
+			if r.read != r.manifest.length {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, io.EOF
+		}
+		chunk, err := r.chunks.GetKV(streamChunkKey(r.key, r.next))
+		if err != nil {
+			return 0, err
+		}
+		if crc32.Checksum(chunk, streamCRCTable) != r.manifest.sums[r.next] {
+			return 0, StreamChecksumMismatch
+		}
+		r.buf = chunk
+		r.read += uint64(len(chunk))
+		r.next++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	err := r.chunks.Close()
+	if serr := r.snap.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package forestdb
+
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	blob := make([]byte, 3*DefaultStreamChunkSize+123)
+	rand.Read(blob)
+	err = kvstore.PutStream([]byte("blob"), bytes.NewReader(blob), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := kvstore.GetStream([]byte("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replacing the stream does not affect an open reader
+	err = kvstore.PutStream([]byte("blob"), bytes.NewReader([]byte("small")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if !bytes.Equal(got, blob) {
+		t.Errorf("expected %d bytes back, got %d", len(blob), len(got))
+	}
+
+	chunks, err := kvstore.streamChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunks.Close()
+
+	// the stale chunks of the larger stream were deleted
+	_, err = chunks.GetKV(streamChunkKey([]byte("blob"), 1))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+	r, err = kvstore.GetStream([]byte("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "small" {
+		t.Errorf("expected small, got %s", got)
+	}
+
+	err = kvstore.DeleteStream([]byte("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kvstore.GetStream([]byte("blob"))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+	_, err = chunks.GetKV(streamChunkKey([]byte("blob"), 0))
+	if err != RESULT_KEY_NOT_FOUND {
+		t.Errorf("expected %v, got %v", RESULT_KEY_NOT_FOUND, err)
+	}
+}
+
+func TestStreamDelete(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	chunks, err := kvstore.streamChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunks.Close()
+
+	// "some data" is stored in 3 chunks of 4 bytes
+	for _, key := range []string{"a", "b"} {
+		err = kvstore.PutStream([]byte(key), bytes.NewReader([]byte("some data")), 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the manifests are in the KVStore itself
+	iter, err := kvstore.IteratorInit(nil, nil, ITR_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for {
+		doc, err := iter.GetMetaOnly()
+		if isIterEnd(err) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(doc.Key()))
+		doc.Close()
+		if iter.Next() != nil {
+			break
+		}
+	}
+	iter.Close()
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("expected [a b], got %q", keys)
+	}
+	_, err = chunks.GetKV(streamChunkKey([]byte("a"), 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvstore.DeleteStream([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = chunks.GetKV(streamChunkKey([]byte("a"), i))
+		if err != RESULT_KEY_NOT_FOUND {
+			t.Errorf("chunk %d of a: expected %v, got %v", i, RESULT_KEY_NOT_FOUND, err)
+		}
+	}
+	// the other stream is untouched
+	_, err = chunks.GetKV(streamChunkKey([]byte("b"), 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamChecksum(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	kvstore, err := OpenFileKVStore("test", nil, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseFileKVStore(kvstore)
+
+	err = kvstore.PutStream([]byte("blob"), bytes.NewReader([]byte("some data")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := kvstore.streamChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunks.Close()
+	err = chunks.SetKV(streamChunkKey([]byte("blob"), 0), []byte("corrupted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := kvstore.GetStream([]byte("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	if err != StreamChecksumMismatch {
+		t.Errorf("expected StreamChecksumMismatch, got %v", err)
+	}
+
+	err = kvstore.SetKV([]byte("plain"), []byte("val"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kvstore.GetStream([]byte("plain"))
+	if err != NotAStream {
+		t.Errorf("expected NotAStream, got %v", err)
+	}
+}
@@ -23,6 +23,9 @@ const (
 )
 
 func (f *File) BeginTransaction(level IsolationLevel) error {
+	if err := f.fault(FAULT_BEGIN_TRANSACTION); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_begin_transaction call f:%p dbfile:%p level:%v", f, f.dbfile, level)
 	errNo := C.fdb_begin_transaction(f.dbfile, C.fdb_isolation_level_t(level))
 	Log.Tracef("fdb_begin_transaction retn f:%p errNo:%v", f, errNo)
@@ -33,6 +36,9 @@ func (f *File) BeginTransaction(level IsolationLevel) error {
 }
 
 func (f *File) EndTransaction(opt CommitOpt) error {
+	if err := f.fault(FAULT_END_TRANSACTION); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_end_transaction call f:%p dbfile:%p opt:%v", f, f.dbfile, opt)
 	errNo := C.fdb_end_transaction(f.dbfile, C.fdb_commit_opt_t(opt))
 	Log.Tracef("fdb_end_transaction retn f:%p errNo:%v", f, errNo)
@@ -43,6 +49,9 @@ func (f *File) EndTransaction(opt CommitOpt) error {
 }
 
 func (f *File) AbortTransaction() error {
+	if err := f.fault(FAULT_ABORT_TRANSACTION); err != nil {
+		return err
+	}
 	Log.Tracef("fdb_abort_transaction call f:%p dbfile:%p", f, f.dbfile)
 	errNo := C.fdb_abort_transaction(f.dbfile)
 	Log.Tracef("fdb_abort_transaction retn f:%p errNo:%v", f, errNo)